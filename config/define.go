@@ -32,6 +32,7 @@ type DiscoverConf struct {
 	Endpoints    []string `json:"endpoints"`
 	ServerPrefix string   `json:"server_prefix"`
 	DataPrefix   string   `json:"data_prefix"`
+	LeaseTTL     int      `json:"lease_ttl"` //注册租约秒数,进程异常退出后注册信息在租约到期后删除,默认10
 }
 
 type RpcConf struct {
@@ -40,6 +41,12 @@ type RpcConf struct {
 	Endpoints   []string `json:"endpoints" mapstructure:"endpoints"`
 	DebugMsg    bool     `json:"debug_msg" mapstructure:"debug_msg"`
 	Prefix      string   `json:"prefix" mapstructure:"prefix"`
+	//消息体超过该字节数时压缩,0表示不压缩
+	CompressThreshold int `json:"compress_threshold" mapstructure:"compress_threshold"`
+	//集群签名key,第一个用于签名,全部用于校验,为空表示不签名
+	SignKeys []string `json:"sign_keys" mapstructure:"sign_keys"`
+	//签名时间窗口,单位秒,默认30
+	SignWindow int `json:"sign_window" mapstructure:"sign_window"`
 }
 
 type StoresConf struct {
@@ -55,7 +62,7 @@ type ConnectorConf struct {
 	UseType           string `json:"use_type"`            //使用的协议
 	UseWebsocket      bool   `json:"use_websocket"`       //是否使用websocket
 	WebsocketPath     string `json:"websocket_path"`      //websocket路径
-	UseSerializer     string `json:"use_serializer"`      //使用的协议
+	UseSerializer     string `json:"use_serializer"`      //使用的协议,serialize中注册的名称,如proto、json、msgpack、protojson
 	ProtoPath         string `json:"proto_path"`          //protobuf位置
 	HeartbeatInterval int    `json:"heartbeat_interval"`  //心跳间隔
 	Version           string `json:"version"`             //当前tcpserver版本号
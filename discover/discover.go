@@ -36,6 +36,7 @@ func InitDiscoverer(cfg config.DiscoverConf) {
 			WithEtcdEndpoints(cfg.Endpoints),
 			WithEtcdServerPrefix(cfg.ServerPrefix),
 			WithEtcdDataPrefix(cfg.DataPrefix),
+			WithEtcdLeaseTTL(time.Duration(cfg.LeaseTTL)*time.Second),
 		)
 	default:
 		logger.Fatal("InitDiscoverer failed")
@@ -114,6 +115,10 @@ func GetServerTypeList(serverType string, options ...FilterOption) map[string]*t
 }
 
 func RegServerEventHandlers(handlers ...ServerEventHandler) {
+	if defDiscoverer == nil {
+		logger.Error("RegServerEventHandlers failed, discoverer not init")
+		return
+	}
 	defDiscoverer.RegServerEventHandlers(handlers...)
 }
 
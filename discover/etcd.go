@@ -34,6 +34,7 @@ const (
 	DefaultServerPrefix = "/server/"
 	DefaultDataPrefix   = "/data/"
 	dumpJobId           = -999
+	DefaultLeaseTTL     = 10 * time.Second
 )
 
 // EtcdDiscoverer etcd discoverer
@@ -50,6 +51,9 @@ type EtcdDiscoverer struct {
 	ServerChecker          *regexp.Regexp
 	DataChecker            *regexp.Regexp
 	RegLock                *sync.Mutex
+	LeaseTTL               time.Duration             //注册租约时长,进程异常退出后注册信息在租约到期后删除
+	leaseId                clientv3.LeaseID          //当前注册租约,由RegLock保护
+	registered             map[string]*treaty.Server //本进程注册的服务器,租约重建后重新写入
 }
 type EtcdOption func(e *EtcdDiscoverer)
 
@@ -71,6 +75,13 @@ func WithEtcdServerPrefix(prefix string) EtcdOption {
 	}
 }
 
+// WithEtcdLeaseTTL 注册租约时长,未设置时为DefaultLeaseTTL
+func WithEtcdLeaseTTL(ttl time.Duration) EtcdOption {
+	return func(e *EtcdDiscoverer) {
+		e.LeaseTTL = ttl
+	}
+}
+
 func WithEtcdDataPrefix(prefix string) EtcdOption {
 	return func(e *EtcdDiscoverer) {
 		prefix = "/" + prefix + "/"
@@ -88,10 +99,14 @@ func NewEtcdDiscoverer(opts ...EtcdOption) *EtcdDiscoverer {
 		ServerEventHandlerList: make([]ServerEventHandler, 0),
 		ServerPrefix:           DefaultServerPrefix,
 		DataPrefix:             DefaultDataPrefix,
+		registered:             make(map[string]*treaty.Server),
 	}
 	for _, opt := range opts {
 		opt(e)
 	}
+	if e.LeaseTTL <= 0 {
+		e.LeaseTTL = DefaultLeaseTTL
+	}
 	e.ServerChecker = regexp.MustCompile(e.checkRule(e.ServerPrefix))
 	e.DataChecker = regexp.MustCompile(e.checkRule(e.DataPrefix))
 	cli, err := clientv3.New(e.Config)
@@ -306,18 +321,101 @@ func (e *EtcdDiscoverer) dumpHeader(header *pb.ResponseHeader) string {
 	return fmt.Sprintf("ClusterId:%v,MemberId:%v,Revision:%v", header.ClusterId, header.MemberId, header.Revision)
 }
 
-// Register register
-func (e *EtcdDiscoverer) Register(server *treaty.Server) error {
+// grantLease 返回注册使用的租约,不存在时创建并保持续约,需持有RegLock
+func (e *EtcdDiscoverer) grantLease() (clientv3.LeaseID, error) {
+	if e.leaseId != clientv3.NoLease {
+		return e.leaseId, nil
+	}
+	ttl := int64(e.LeaseTTL / time.Second)
+	if ttl < 1 {
+		ttl = 1
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), e.Config.DialTimeout)
+	defer cancel()
+	resp, err := e.Client.Grant(ctx, ttl)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	ch, err := e.Client.KeepAlive(e.Client.Ctx(), resp.ID)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	e.leaseId = resp.ID
+	go utils.SafeRun(func() {
+		e.keepAlive(resp.ID, ch)
+	})
+	logger.Infof("discover lease granted, leaseId:%x, ttl:%v", resp.ID, ttl)
+	return resp.ID, nil
+}
+
+// keepAlive 续约中断(会话丢失或租约已过期)后重建租约,并重新写入本进程注册的服务器,客户端关闭时退出
+func (e *EtcdDiscoverer) keepAlive(id clientv3.LeaseID, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for range ch {
+	}
+	ctx := e.Client.Ctx()
+	if ctx.Err() != nil {
+		return
+	}
+	logger.Warnf("discover lease lost, leaseId:%x", id)
+	backoff := time.Second
+	for {
+		err := e.reRegister(id)
+		if err == nil {
+			return
+		}
+		logger.Errorf("discover lease re-establish failed, retry after:%v, err:%v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > e.LeaseTTL {
+			backoff = e.LeaseTTL
+		}
+	}
+}
+
+func (e *EtcdDiscoverer) reRegister(lost clientv3.LeaseID) error {
 	e.RegLock.Lock()
 	defer e.RegLock.Unlock()
-	kv := clientv3.NewKV(e.Client)
+	if e.leaseId == lost {
+		e.leaseId = clientv3.NoLease
+	}
+	id, err := e.grantLease()
+	if err != nil {
+		return err
+	}
+	for key, server := range e.registered {
+		if _, err = e.putServer(key, server, id); err != nil {
+			return err
+		}
+	}
+	logger.Infof("discover lease re-established, leaseId:%x, servers:%v", id, len(e.registered))
+	return nil
+}
+
+// putServer 以租约写入服务器信息
+func (e *EtcdDiscoverer) putServer(key string, server *treaty.Server, id clientv3.LeaseID) (*clientv3.PutResponse, error) {
 	ctx, cancel := context.WithTimeout(context.TODO(), e.Config.DialTimeout)
 	defer cancel()
+	return e.Client.Put(ctx, key, treaty.RegSerialize(server), clientv3.WithLease(id))
+}
+
+// Register register
+// 注册服务器,绑定到共用的租约
+func (e *EtcdDiscoverer) Register(server *treaty.Server) error {
+	e.RegLock.Lock()
+	defer e.RegLock.Unlock()
+	id, err := e.grantLease()
+	if err != nil {
+		return err
+	}
 	key, val := e.serverKey(server), treaty.RegSerialize(server)
-	resp, err := kv.Put(ctx, key, val)
+	resp, err := e.putServer(key, server, id)
 	if err != nil {
 		return err
 	}
+	e.registered[key] = server
 	if atomic.LoadInt32(&server.Silent) == 0 {
 		logger.Infof("discover Register server,%s=>%s,resp:%v", key, val, e.dumpHeader(resp.Header))
 	}
@@ -333,14 +431,16 @@ func (e *EtcdDiscoverer) RegisterLoad(server *treaty.Server, load int64) error {
 	if atomic.LoadInt64(&server.Load) < 0 {
 		atomic.StoreInt64(&server.Load, 0)
 	}
-	kv := clientv3.NewKV(e.Client)
-	ctx, cancel := context.WithTimeout(context.TODO(), e.Config.DialTimeout)
-	defer cancel()
+	id, err := e.grantLease()
+	if err != nil {
+		return err
+	}
 	key, val := e.serverKey(server), treaty.RegSerialize(server)
-	resp, err := kv.Put(ctx, key, val)
+	resp, err := e.putServer(key, server, id)
 	if err != nil {
 		return err
 	}
+	e.registered[key] = server
 	if atomic.LoadInt32(&server.Silent) == 0 {
 		logger.Infof("discover Register server,%s=>%s,resp:%v", key, val, e.dumpHeader(resp.Header))
 	}
@@ -348,10 +448,14 @@ func (e *EtcdDiscoverer) RegisterLoad(server *treaty.Server, load int64) error {
 }
 
 func (e *EtcdDiscoverer) UnRegister(server *treaty.Server) error {
+	e.RegLock.Lock()
+	defer e.RegLock.Unlock()
 	kv := clientv3.NewKV(e.Client)
 	ctx, cancel := context.WithTimeout(context.TODO(), e.Config.DialTimeout)
 	defer cancel()
-	if resp, err := kv.Delete(ctx, e.serverKey(server), clientv3.WithPrevKV()); err != nil {
+	key := e.serverKey(server)
+	delete(e.registered, key)
+	if resp, err := kv.Delete(ctx, key, clientv3.WithPrevKV()); err != nil {
 		return err
 	} else if atomic.LoadInt32(&server.Silent) == 0 {
 		logger.Infof("discover unregister serverId:%v, resp:%+v", server.ServerId, e.dumpHeader(resp.Header))
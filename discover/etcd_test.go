@@ -0,0 +1,178 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package discover
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// fakeLease 租约由测试控制,关闭续约通道模拟租约丢失
+type fakeLease struct {
+	clientv3.Lease
+	lock   sync.Mutex
+	next   clientv3.LeaseID
+	grants int
+	fail   bool
+	alive  map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse
+}
+
+func (l *fakeLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.grants++
+	if l.fail {
+		return nil, errors.New("etcd unavailable")
+	}
+	l.next++
+	return &clientv3.LeaseGrantResponse{ID: l.next, TTL: ttl}, nil
+}
+
+func (l *fakeLease) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	l.alive[id] = ch
+	return ch, nil
+}
+
+func (l *fakeLease) Close() error {
+	return nil
+}
+
+func (l *fakeLease) lose(id clientv3.LeaseID) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	close(l.alive[id])
+}
+
+func (l *fakeLease) grantCount() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.grants
+}
+
+type fakeKV struct {
+	clientv3.KV
+	lock sync.Mutex
+	puts map[string]int
+}
+
+func (kv *fakeKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	kv.puts[key]++
+	return &clientv3.PutResponse{Header: &pb.ResponseHeader{}}, nil
+}
+
+func (kv *fakeKV) count(key string) int {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	return kv.puts[key]
+}
+
+func newFakeEtcd() (*EtcdDiscoverer, *fakeLease, *fakeKV) {
+	lease := &fakeLease{alive: make(map[clientv3.LeaseID]chan *clientv3.LeaseKeepAliveResponse)}
+	kv := &fakeKV{puts: make(map[string]int)}
+	cli := clientv3.NewCtxClient(context.Background())
+	cli.Lease, cli.KV = lease, kv
+	e := &EtcdDiscoverer{
+		Config:       clientv3.Config{DialTimeout: time.Second},
+		Client:       cli,
+		ServerPrefix: DefaultServerPrefix,
+		RegLock:      new(sync.Mutex),
+		LeaseTTL:     time.Second,
+		registered:   make(map[string]*treaty.Server),
+	}
+	return e, lease, kv
+}
+
+func (e *EtcdDiscoverer) currentLease() clientv3.LeaseID {
+	e.RegLock.Lock()
+	defer e.RegLock.Unlock()
+	return e.leaseId
+}
+
+func TestEtcdReRegister(t *testing.T) {
+	e, lease, kv := newFakeEtcd()
+	defer e.Client.Close()
+	servers := []*treaty.Server{
+		{ServerId: "1001", ServerType: "backend"},
+		{ServerId: "1002", ServerType: "backend"},
+		{ServerId: "2001", ServerType: "connector"},
+	}
+	for _, server := range servers {
+		if err := e.Register(server); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.RegisterLoad(servers[0], 1); err != nil {
+		t.Fatal(err)
+	}
+	if id := e.currentLease(); id != 1 || lease.grantCount() != 1 {
+		t.Fatalf("servers should share one lease, leaseId:%v, grants:%v", id, lease.grantCount())
+	}
+	//租约丢失后以新租约重新写入全部注册的服务器
+	lease.lose(1)
+	deadline := time.Now().Add(time.Second)
+	for e.currentLease() != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if id := e.currentLease(); id != 2 {
+		t.Fatalf("lease not re-established:%v", id)
+	}
+	for _, server := range servers {
+		want := 2
+		if server == servers[0] {
+			want = 3
+		}
+		if n := kv.count(e.serverKey(server)); n != want {
+			t.Fatalf("server %v not restored, puts:%v", server.ServerId, n)
+		}
+	}
+}
+
+func TestEtcdKeepAliveStop(t *testing.T) {
+	e, lease, _ := newFakeEtcd()
+	if err := e.Register(&treaty.Server{ServerId: "1001", ServerType: "backend"}); err != nil {
+		t.Fatal(err)
+	}
+	//重建失败后等待重试期间客户端关闭,续约协程退出
+	lease.lock.Lock()
+	lease.fail = true
+	lease.lock.Unlock()
+	done := make(chan struct{})
+	ch := make(chan *clientv3.LeaseKeepAliveResponse)
+	close(ch)
+	go func() {
+		e.keepAlive(e.currentLease(), ch)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for lease.grantCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_ = e.Client.Close()
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("keepAlive not stopped after client closed")
+	}
+}
@@ -26,6 +26,7 @@ type Filter struct {
 	maintainType MaintainType
 	version      int64
 	ignore       bool //忽略具体状态检查
+	exclude      map[string]bool
 }
 
 func NewFilter(options ...FilterOption) *Filter {
@@ -41,6 +42,9 @@ func NewFilter(options ...FilterOption) *Filter {
 }
 
 func (f *Filter) apply(s *treaty.Server) bool {
+	if f.exclude[s.ServerId] {
+		return false
+	}
 	if f.maintainType > MaintainTypeAll {
 		if f.ignore {
 			return true
@@ -77,3 +81,15 @@ func FilterIgnore(ignore bool) FilterOption {
 		f.ignore = ignore
 	}
 }
+
+// FilterExclude 排除指定服务器
+func FilterExclude(serverIds ...string) FilterOption {
+	return func(f *Filter) {
+		if f.exclude == nil {
+			f.exclude = make(map[string]bool, len(serverIds))
+		}
+		for _, serverId := range serverIds {
+			f.exclude[serverId] = true
+		}
+	}
+}
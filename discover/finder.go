@@ -31,6 +31,7 @@ type Finder struct {
 	serversInt map[string]ServerMap[int64]
 	serversStr map[string]ServerMap[string]
 	serverLock *sync.RWMutex
+	excluded   map[string]bool //暂停路由的服务器,如熔断中
 }
 
 func NewFinder() *Finder {
@@ -38,6 +39,7 @@ func NewFinder() *Finder {
 		serversInt: make(map[string]ServerMap[int64]),
 		serversStr: make(map[string]ServerMap[string]),
 		serverLock: new(sync.RWMutex),
+		excluded:   make(map[string]bool),
 	}
 	RegServerEventHandlers(f.ServerEventHandler)
 	return f
@@ -61,13 +63,13 @@ func (f *Finder) GetServerCache(serverType string, arg any) *treaty.Server {
 	switch v := arg.(type) {
 	case int64:
 		if serverTypeList, ok := f.serversInt[serverType]; ok {
-			if server, okv := serverTypeList[v]; okv {
+			if server, okv := serverTypeList[v]; okv && !f.excluded[server.ServerId] {
 				return server
 			}
 		}
 	case string:
 		if serverTypeList, ok := f.serversStr[serverType]; ok {
-			if server, okv := serverTypeList[v]; okv {
+			if server, okv := serverTypeList[v]; okv && !f.excluded[server.ServerId] {
 				return server
 			}
 		}
@@ -79,7 +81,7 @@ func (f *Finder) GetServerCache(serverType string, arg any) *treaty.Server {
 func (f *Finder) GetServerDiscover(serverType string, arg any, options ...FilterOption) *treaty.Server {
 	f.serverLock.Lock()
 	defer f.serverLock.Unlock()
-	server := GetServerByType(serverType, fmt.Sprintf("%v", arg), options...)
+	server := f.discover(serverType, fmt.Sprintf("%v", arg), options...)
 	if server != nil {
 		switch v := arg.(type) {
 		case int64:
@@ -102,6 +104,48 @@ func (f *Finder) GetServerDiscover(serverType string, arg any, options ...Filter
 	return nil
 }
 
+// discover 避开暂停路由的服务器,没有其他可用服务器时仍使用原服务器
+func (f *Finder) discover(serverType, serverArg string, options ...FilterOption) *treaty.Server {
+	if len(f.excluded) > 0 {
+		excluded := make([]string, 0, len(f.excluded))
+		for serverId := range f.excluded {
+			excluded = append(excluded, serverId)
+		}
+		if server := GetServerByType(serverType, serverArg, append(options, FilterExclude(excluded...))...); server != nil {
+			return server
+		}
+	}
+	return GetServerByType(serverType, serverArg, options...)
+}
+
+// Exclude 暂停向serverId路由,已缓存到该服务器的用户重新选择服务器
+func (f *Finder) Exclude(serverId string) {
+	f.serverLock.Lock()
+	defer f.serverLock.Unlock()
+	f.excluded[serverId] = true
+	for _, val := range f.serversInt {
+		for arg, server := range val {
+			if server.ServerId == serverId {
+				delete(val, arg)
+			}
+		}
+	}
+	for _, val := range f.serversStr {
+		for arg, server := range val {
+			if server.ServerId == serverId {
+				delete(val, arg)
+			}
+		}
+	}
+}
+
+// Include 恢复向serverId路由,已迁移的用户保持在新服务器
+func (f *Finder) Include(serverId string) {
+	f.serverLock.Lock()
+	defer f.serverLock.Unlock()
+	delete(f.excluded, serverId)
+}
+
 func (f *Finder) GetUserServer(serverType string, arg any, options ...FilterOption) *treaty.Server {
 	if server := f.GetServerCache(serverType, arg); server != nil {
 		return server
@@ -3,6 +3,7 @@ module github.com/fengyuqin/kungfu/v2
 go 1.20
 
 require (
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/appleboy/gin-jwt/v2 v2.8.0
 	github.com/fatih/color v1.13.0
 	github.com/gin-gonic/gin v1.7.7
@@ -14,6 +15,7 @@ require (
 	github.com/googollee/go-socket.io v1.6.1
 	github.com/gorilla/websocket v1.4.2
 	github.com/json-iterator/go v1.1.12
+	github.com/nats-io/nats-server/v2 v2.7.2
 	github.com/nats-io/nats.go v1.15.0
 	github.com/sleagon/chinaid v0.4.2
 	github.com/spf13/viper v1.11.0
@@ -23,6 +25,7 @@ require (
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.0.502
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/sms v1.0.502
 	github.com/tencentyun/cos-go-sdk-v5 v0.7.35
+	github.com/ugorji/go/codec v1.1.7
 	github.com/urfave/cli/v2 v2.6.0
 	go.etcd.io/etcd/client/v3 v3.5.0-alpha.0
 	go.uber.org/ratelimit v0.2.0
@@ -39,9 +42,13 @@ require (
 	github.com/go-playground/validator/v10 v10.4.1 // indirect
 	github.com/golang-jwt/jwt/v4 v4.2.0 // indirect
 	github.com/google/go-querystring v1.0.0 // indirect
+	github.com/klauspost/compress v1.13.4 // indirect
 	github.com/leodido/go-urn v1.2.0 // indirect
+	github.com/minio/highwayhash v1.0.1 // indirect
 	github.com/mozillazg/go-httpheader v0.2.1 // indirect
-	github.com/ugorji/go/codec v1.1.7 // indirect
+	github.com/nats-io/jwt/v2 v2.2.1-0.20220113022732-58e87895b296 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/time v0.0.0-20211116232009-f0f3c7e86c11 // indirect
 )
 
 require (
@@ -64,7 +71,6 @@ require (
 	github.com/mitchellh/mapstructure v1.4.3 // indirect
 	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
 	github.com/modern-go/reflect2 v1.0.2 // indirect
-	github.com/nats-io/nats-server/v2 v2.7.2 // indirect
 	github.com/nats-io/nkeys v0.3.0 // indirect
 	github.com/nats-io/nuid v1.0.1 // indirect
 	github.com/pelletier/go-toml v1.9.4 // indirect
@@ -113,14 +113,11 @@ func NewAgent(server tcpface.IServer, conn net.Conn, connId int) *Agent {
 	s := session.NewSession(a.connId, a)
 	a.session = s
 	a.srv = reflect.ValueOf(s)
-	switch cfg.UseSerializer {
-	case "proto":
-		a.serializer = serialize.NewProtoSerializer()
-	case "json":
-		a.serializer = serialize.NewJsonSerializer()
-	default:
+	serializer, err := serialize.Get(cfg.UseSerializer)
+	if err != nil {
 		logger.Fatalf("no suitable serializer:%v", cfg.UseSerializer)
 	}
+	a.serializer = serializer
 
 	return a
 }
@@ -74,14 +74,11 @@ func NewMsgHandle() *MsgHandle {
 		Cfg:       cfg,
 	}
 	h.hbdEncode()
-	switch cfg.UseSerializer {
-	case "proto":
-		h.Serializer = serialize.NewProtoSerializer()
-	case "json":
-		h.Serializer = serialize.NewJsonSerializer()
-	default:
+	serializer, err := serialize.Get(cfg.UseSerializer)
+	if err != nil {
 		logger.Fatalf("no suitable serializer:%v", cfg.UseSerializer)
 	}
+	h.Serializer = serializer
 	return h
 }
 
@@ -94,7 +91,7 @@ func (h *MsgHandle) hbdEncode() {
 		"sys":  sys,
 	}
 
-	if h.Cfg.UseSerializer == "proto" {
+	if h.Cfg.UseSerializer == serialize.Proto {
 		ps, err := LoadProtobuf(h.Cfg.ProtoPath)
 		if err != nil {
 			logger.Fatal(err)
@@ -0,0 +1,56 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package plugin
+
+import (
+	"sync"
+
+	"github.com/fengyuqin/kungfu/v2/rpc"
+)
+
+var (
+	defBreaker     *rpc.CircuitBreaker
+	defBreakerOnce sync.Once //默认rpc为进程共享,只由第一个初始化的ServerBreaker安装熔断
+)
+
+// ServerBreaker 本服务rpc及默认rpc的出站调用熔断,熔断期间用户路由到同类型其他服务器
+type ServerBreaker struct {
+	Breaker    *rpc.CircuitBreaker //本服务rpc
+	DefBreaker *rpc.CircuitBreaker //默认rpc,进程内共享,使用第一个初始化的ServerBreaker的配置
+	opts       []rpc.BreakerOption
+}
+
+func NewServerBreaker(opts ...rpc.BreakerOption) *ServerBreaker {
+	return &ServerBreaker{
+		opts: opts,
+	}
+}
+
+func (b *ServerBreaker) Init(s *rpc.ServerBase) {
+	b.Breaker = rpc.NewCircuitBreaker(append(b.opts, rpc.WithBreakerFinder(s.Rpc.GetFinder()))...)
+	s.Rpc.UseClientInterceptors(b.Breaker.Interceptor())
+	defBreakerOnce.Do(func() {
+		defBreaker = rpc.NewCircuitBreaker(append(b.opts, rpc.WithBreakerFinder(rpc.GetFinder()))...)
+		rpc.UseClientInterceptors(defBreaker.Interceptor())
+	})
+	b.DefBreaker = defBreaker
+}
+
+func (b *ServerBreaker) AfterInit(s *rpc.ServerBase) {
+}
+
+func (b *ServerBreaker) BeforeShutdown(s *rpc.ServerBase) {
+}
+
+func (b *ServerBreaker) Shutdown(s *rpc.ServerBase) {
+}
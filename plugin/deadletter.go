@@ -0,0 +1,89 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package plugin
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/rpc"
+	"github.com/fengyuqin/kungfu/v2/utils"
+)
+
+// ServerDeadLetter 本服务rpc解码失败及处理器panic的消息写入死信队列,
+// addr非空时在/deadletters提供查看、重新处理及删除接口,addr未指定主机时仅监听本机,
+// 监听其他地址时需通过rpc.WithDeadLetterToken设置访问令牌
+type ServerDeadLetter struct {
+	Queue       rpc.DeadLetterQueue
+	AdminServer *http.Server
+	addr        string
+	opts        []rpc.DeadLetterAdminOption
+}
+
+// NewServerDeadLetter queue通常为rpc.NewRedisDeadLetters(stores.GetDefStoreKeeper())
+func NewServerDeadLetter(queue rpc.DeadLetterQueue, addr string, opts ...rpc.DeadLetterAdminOption) *ServerDeadLetter {
+	if host, port, err := net.SplitHostPort(addr); err == nil && len(host) < 1 {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	return &ServerDeadLetter{
+		Queue: queue,
+		addr:  addr,
+		opts:  opts,
+	}
+}
+
+func (b *ServerDeadLetter) Init(s *rpc.ServerBase) {
+	r, ok := s.Rpc.(rpc.DeadLetterRpc)
+	if !ok {
+		logger.Errorf("ServerDeadLetter rpc not support dead letter:%T", s.Rpc)
+		return
+	}
+	r.UseDeadLetter(b.Queue)
+	if len(b.addr) < 1 {
+		return
+	}
+	admin := rpc.NewDeadLetterAdmin(b.Queue, r, b.opts...)
+	mux := http.NewServeMux()
+	mux.Handle("/deadletters", admin)
+	mux.Handle("/deadletters/replay", admin)
+	b.AdminServer = &http.Server{Addr: b.addr, Handler: mux}
+}
+
+func (b *ServerDeadLetter) Run(s *rpc.ServerBase) {
+	logger.Infof("ServerDeadLetter start at:%v, server:%v", b.addr, s.Server.ServerId)
+	if err := b.AdminServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err)
+	}
+}
+
+func (b *ServerDeadLetter) AfterInit(s *rpc.ServerBase) {
+	if b.AdminServer == nil {
+		return
+	}
+	go utils.SafeRun(func() {
+		b.Run(s)
+	})
+}
+
+func (b *ServerDeadLetter) BeforeShutdown(s *rpc.ServerBase) {
+}
+
+func (b *ServerDeadLetter) Shutdown(s *rpc.ServerBase) {
+	if b.AdminServer != nil {
+		if err := b.AdminServer.Close(); err != nil {
+			logger.Error(err)
+		}
+	}
+}
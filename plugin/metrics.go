@@ -0,0 +1,70 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package plugin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/rpc"
+	"github.com/fengyuqin/kungfu/v2/utils"
+)
+
+// ServerMetrics 统计rpc调用并以prometheus文本格式暴露在addr的/metrics
+type ServerMetrics struct {
+	Metrics      *rpc.RpcMetrics
+	MetricServer *http.Server
+	addr         string
+	buckets      []float64
+}
+
+// NewServerMetrics addr如":9100",buckets为空时使用默认耗时分桶
+func NewServerMetrics(addr string, buckets ...float64) *ServerMetrics {
+	return &ServerMetrics{
+		addr:    addr,
+		buckets: buckets,
+	}
+}
+
+func (b *ServerMetrics) Init(s *rpc.ServerBase) {
+	b.Metrics = rpc.NewRpcMetrics(s.Server.ServerType, b.buckets...)
+	s.UseMetrics(b.Metrics)
+}
+
+func (b *ServerMetrics) Run(s *rpc.ServerBase) {
+	logger.Infof("ServerMetrics start at:%v, server:%v", b.addr, s.Server.ServerId)
+	if err := b.MetricServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err)
+	}
+}
+
+func (b *ServerMetrics) AfterInit(s *rpc.ServerBase) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", b.Metrics)
+	b.MetricServer = &http.Server{Addr: b.addr, Handler: mux}
+	go utils.SafeRun(func() {
+		b.Run(s)
+	})
+}
+
+func (b *ServerMetrics) BeforeShutdown(s *rpc.ServerBase) {
+}
+
+func (b *ServerMetrics) Shutdown(s *rpc.ServerBase) {
+	if b.MetricServer != nil {
+		if err := b.MetricServer.Close(); err != nil {
+			logger.Error(err)
+		}
+	}
+}
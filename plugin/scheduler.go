@@ -0,0 +1,59 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package plugin
+
+import (
+	"sync"
+
+	"github.com/fengyuqin/kungfu/v2/rpc"
+	"github.com/go-redis/redis/v8"
+)
+
+// defSchedulerOnce 默认rpc为进程共享,只安装第一个初始化的ServerScheduler的调度
+var defSchedulerOnce sync.Once
+
+// ServerScheduler 延迟消息调度,本服务rpc发出的延迟消息写入redis,到期后由本服务投递;
+// 默认rpc发出的延迟消息只写入进程内第一个初始化的ServerScheduler的Key,由轮询该Key的调度投递,
+// 该服务关闭后仍写入该Key,需有其他实例使用相同Key轮询
+type ServerScheduler struct {
+	Scheduler *rpc.Scheduler
+	client    *redis.Client
+	opts      []rpc.SchedulerOption
+}
+
+// NewServerScheduler client通常为stores.StoreRedis的Client
+func NewServerScheduler(client *redis.Client, opts ...rpc.SchedulerOption) *ServerScheduler {
+	return &ServerScheduler{
+		client: client,
+		opts:   opts,
+	}
+}
+
+func (b *ServerScheduler) Init(s *rpc.ServerBase) {
+	b.Scheduler = rpc.NewScheduler(s.Rpc, b.client, b.opts...)
+	s.Rpc.UseClientInterceptors(b.Scheduler.Interceptor())
+	defSchedulerOnce.Do(func() {
+		rpc.UseClientInterceptors(b.Scheduler.Interceptor())
+	})
+}
+
+func (b *ServerScheduler) AfterInit(s *rpc.ServerBase) {
+	b.Scheduler.Start()
+}
+
+func (b *ServerScheduler) BeforeShutdown(s *rpc.ServerBase) {
+}
+
+func (b *ServerScheduler) Shutdown(s *rpc.ServerBase) {
+	b.Scheduler.Stop()
+}
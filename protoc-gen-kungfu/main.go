@@ -0,0 +1,189 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+// protoc-gen-kungfu 根据proto服务定义生成msgId常量及映射、类型化客户端及服务注册函数
+//
+//	import "kungfu/options.proto";
+//
+//	service Hall {
+//	  rpc GetOnline(OnlineReq) returns (OnlineResp) { option (kungfu.msg_id) = 1001; }
+//	  rpc ListMembers(ListReq) returns (stream Member) { option (kungfu.msg_id) = 1002; }
+//	}
+//
+//	protoc -I. -I$KUNGFU/protoc-gen-kungfu --go_out=. --kungfu_out=. hall.proto
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/fengyuqin/kungfu/v2/protoc-gen-kungfu/kungfu"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	contextPackage = protogen.GoImportPath("context")
+	rpcPackage     = protogen.GoImportPath("github.com/fengyuqin/kungfu/v2/rpc")
+	treatyPackage  = protogen.GoImportPath("github.com/fengyuqin/kungfu/v2/treaty")
+)
+
+func main() {
+	protogen.Options{}.Run(func(gen *protogen.Plugin) error {
+		for _, f := range gen.Files {
+			if !f.Generate || len(f.Services) < 1 {
+				continue
+			}
+			if err := generateFile(gen, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// methodMsgId 从方法选项读取msg_id
+func methodMsgId(method *protogen.Method) (int32, bool) {
+	opts, ok := method.Desc.Options().(*descriptorpb.MethodOptions)
+	if !ok || opts == nil || !proto.HasExtension(opts, kungfu.E_MsgId) {
+		return 0, false
+	}
+	return proto.GetExtension(opts, kungfu.E_MsgId).(int32), true
+}
+
+func msgIdName(service *protogen.Service, method *protogen.Method) string {
+	return service.GoName + "_" + method.GoName + "_MsgId"
+}
+
+func generateFile(gen *protogen.Plugin, file *protogen.File) error {
+	ids := make(map[int32]string)
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if method.Desc.IsStreamingClient() {
+				return fmt.Errorf("%v: client streaming not supported", method.Desc.FullName())
+			}
+			msgId, ok := methodMsgId(method)
+			if !ok {
+				return fmt.Errorf("%v: missing option (kungfu.msg_id)", method.Desc.FullName())
+			}
+			if exist, ok := ids[msgId]; ok {
+				return fmt.Errorf("%v: msg_id %v already used by %v", method.Desc.FullName(), msgId, exist)
+			}
+			ids[msgId] = string(method.Desc.FullName())
+		}
+	}
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_kungfu.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-kungfu. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+	return nil
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	if len(service.Methods) < 1 {
+		return
+	}
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	server := g.QualifiedGoIdent(treatyPackage.Ident("Server"))
+	callOption := g.QualifiedGoIdent(rpcPackage.Ident("CallOption"))
+	streamSender := g.QualifiedGoIdent(rpcPackage.Ident("StreamSender"))
+
+	//msgId常量
+	g.P("// ", service.GoName, " msgIds")
+	g.P("const (")
+	for _, method := range service.Methods {
+		msgId, _ := methodMsgId(method)
+		g.P(msgIdName(service, method), " int32 = ", msgId)
+	}
+	g.P(")")
+	g.P()
+
+	//方法名到msgId的映射,用于rpc.WithServiceMsgIds
+	g.P("// ", service.GoName, "_MsgIds ", service.GoName, "服务方法名到msgId的映射")
+	g.P("var ", service.GoName, "_MsgIds = map[string]int32{")
+	for _, method := range service.Methods {
+		g.P(strconv.Quote(method.GoName), ": ", msgIdName(service, method), ",")
+	}
+	g.P("}")
+	g.P()
+
+	//服务端接口
+	serverName := service.GoName + "Server"
+	g.P("// ", serverName, " ", service.GoName, "服务端接口")
+	g.P("type ", serverName, " interface {")
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingServer() {
+			g.P(method.GoName, "(ctx ", ctx, ", req *", method.Input.GoIdent, ", stream *", streamSender, ") error")
+		} else {
+			g.P(method.GoName, "(ctx ", ctx, ", req *", method.Input.GoIdent, ") (*", method.Output.GoIdent, ", error)")
+		}
+	}
+	g.P("}")
+	g.P()
+
+	//服务注册
+	g.P("// Register", serverName, " 注册", service.GoName, "服务的全部处理器")
+	g.P("func Register", serverName, "(base *", rpcPackage.Ident("ServerBase"), ", impl ", serverName, ") {")
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingServer() {
+			g.P("base.RegisterStream(", msgIdName(service, method), ", impl.", method.GoName, ")")
+		} else {
+			g.P("base.Register(", msgIdName(service, method), ", impl.", method.GoName, ")")
+		}
+	}
+	g.P("}")
+	g.P()
+
+	//客户端
+	clientName := service.GoName + "Client"
+	g.P("// ", clientName, " ", service.GoName, "服务类型化客户端")
+	g.P("type ", clientName, " struct {")
+	g.P("rpc ", rpcPackage.Ident("ServerRpc"))
+	g.P("}")
+	g.P()
+	g.P("func New", clientName, "(r ", rpcPackage.Ident("ServerRpc"), ") *", clientName, " {")
+	g.P("return &", clientName, "{rpc: r}")
+	g.P("}")
+	g.P()
+	for _, method := range service.Methods {
+		in, out := method.Input.GoIdent, method.Output.GoIdent
+		if method.Desc.IsStreamingServer() {
+			g.P("// ", method.GoName, " 向指定服务器发起流式请求")
+			g.P("func (c *", clientName, ") ", method.GoName, "(ctx ", ctx, ", server *", server, ", req *", in, ", opts ...", callOption, ") (*", rpcPackage.Ident("StreamIter"), "[", out, "], error) {")
+			g.P("return ", rpcPackage.Ident("CallStreamWith"), "[", in, ", ", out, "](ctx, c.rpc, server, ", msgIdName(service, method), ", req, opts...)")
+			g.P("}")
+			g.P()
+			g.P("// ", method.GoName, "Queue 向指定类型服务器的队列发起流式请求")
+			g.P("func (c *", clientName, ") ", method.GoName, "Queue(ctx ", ctx, ", serverType string, req *", in, ", opts ...", callOption, ") (*", rpcPackage.Ident("StreamIter"), "[", out, "], error) {")
+			g.P("return ", rpcPackage.Ident("CallQueueStreamWith"), "[", in, ", ", out, "](ctx, c.rpc, serverType, ", msgIdName(service, method), ", req, opts...)")
+			g.P("}")
+			g.P()
+			continue
+		}
+		g.P("// ", method.GoName, " 向指定服务器发起请求")
+		g.P("func (c *", clientName, ") ", method.GoName, "(ctx ", ctx, ", server *", server, ", req *", in, ", opts ...", callOption, ") (*", out, ", error) {")
+		g.P("return ", rpcPackage.Ident("CallWith"), "[", in, ", ", out, "](ctx, c.rpc, server, ", msgIdName(service, method), ", req, opts...)")
+		g.P("}")
+		g.P()
+		g.P("// ", method.GoName, "Queue 向指定类型服务器的队列发起请求")
+		g.P("func (c *", clientName, ") ", method.GoName, "Queue(ctx ", ctx, ", serverType string, req *", in, ", opts ...", callOption, ") (*", out, ", error) {")
+		g.P("return ", rpcPackage.Ident("CallQueueWith"), "[", in, ", ", out, "](ctx, c.rpc, serverType, ", msgIdName(service, method), ", req, opts...)")
+		g.P("}")
+		g.P()
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fengyuqin/kungfu/v2/protoc-gen-kungfu/kungfu"
+	gengo "google.golang.org/protobuf/cmd/protoc-gen-go/internal_gengo"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func testMethod(name, in, out string, msgId int32, stream bool) *descriptorpb.MethodDescriptorProto {
+	opts := &descriptorpb.MethodOptions{}
+	if msgId > 0 {
+		proto.SetExtension(opts, kungfu.E_MsgId, msgId)
+	}
+	return &descriptorpb.MethodDescriptorProto{
+		Name:            proto.String(name),
+		InputType:       proto.String(in),
+		OutputType:      proto.String(out),
+		ServerStreaming: proto.Bool(stream),
+		Options:         opts,
+	}
+}
+
+// testRequest hall.proto引用kungfu/options.proto,与protoc传给插件的请求一致
+func testRequest(methods ...*descriptorpb.MethodDescriptorProto) (*pluginpb.CodeGeneratorRequest, error) {
+	msg := func(name string) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	}
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"hall.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto),
+			protodesc.ToFileDescriptorProto(kungfu.File_kungfu_options_proto),
+			{
+				Name:        proto.String("hall.proto"),
+				Package:     proto.String("hall"),
+				Syntax:      proto.String("proto3"),
+				Dependency:  []string{"kungfu/options.proto"},
+				Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/hall")},
+				MessageType: []*descriptorpb.DescriptorProto{msg("OnlineReq"), msg("OnlineResp"), msg("Member")},
+				Service: []*descriptorpb.ServiceDescriptorProto{{
+					Name:   proto.String("Hall"),
+					Method: methods,
+				}},
+			},
+		},
+	}
+	//经过序列化,选项按插件收到的字节解析
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	req = &pluginpb.CodeGeneratorRequest{}
+	return req, proto.Unmarshal(data, req)
+}
+
+func testGenerate(methods ...*descriptorpb.MethodDescriptorProto) (*pluginpb.CodeGeneratorResponse, error) {
+	req, err := testRequest(methods...)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range gen.Files {
+		if f.Generate {
+			if err = generateFile(gen, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return gen.Response(), nil
+}
+
+func TestGenerateFile(t *testing.T) {
+	resp, err := testGenerate(
+		testMethod("GetOnline", ".hall.OnlineReq", ".hall.OnlineResp", 1001, false),
+		testMethod("ListMembers", ".hall.OnlineReq", ".hall.Member", 1002, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil || len(resp.File) != 1 {
+		t.Fatalf("generate failed:%v", resp.GetError())
+	}
+	content := resp.File[0].GetContent()
+	for _, want := range []string{
+		"Hall_GetOnline_MsgId   int32 = 1001",
+		"Hall_ListMembers_MsgId int32 = 1002",
+		`"GetOnline":   Hall_GetOnline_MsgId,`,
+		"func RegisterHallServer(base *rpc.ServerBase, impl HallServer)",
+		"base.Register(Hall_GetOnline_MsgId, impl.GetOnline)",
+		"base.RegisterStream(Hall_ListMembers_MsgId, impl.ListMembers)",
+		"ListMembers(ctx context.Context, req *OnlineReq, stream *rpc.StreamSender) error",
+		"rpc.CallWith[OnlineReq, OnlineResp](ctx, c.rpc, server, Hall_GetOnline_MsgId, req, opts...)",
+		"(*rpc.StreamIter[Member], error)",
+	} {
+		if !strings.Contains(content, want) {
+			t.Fatalf("generated code missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestGenerateFileInvalid(t *testing.T) {
+	if _, err := testGenerate(testMethod("GetOnline", ".hall.OnlineReq", ".hall.OnlineResp", 0, false)); err == nil {
+		t.Fatal("want missing msg_id error")
+	}
+	_, err := testGenerate(
+		testMethod("GetOnline", ".hall.OnlineReq", ".hall.OnlineResp", 1001, false),
+		testMethod("GetOffline", ".hall.OnlineReq", ".hall.OnlineResp", 1001, false),
+	)
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("want duplicate msg_id error, got:%v", err)
+	}
+}
+
+// TestGenerateCompile protoc-gen-go及本插件的输出一起编译,引用的kungfu/options.proto须有对应的go包
+func TestGenerateCompile(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	req, err := testRequest(
+		testMethod("GetOnline", ".hall.OnlineReq", ".hall.OnlineResp", 1001, false),
+		testMethod("ListMembers", ".hall.OnlineReq", ".hall.Member", 1002, true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range gen.Files {
+		if f.Generate {
+			gengo.GenerateFile(gen, f)
+			if err = generateFile(gen, f); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	resp := gen.Response()
+	if resp.Error != nil || len(resp.File) != 2 {
+		t.Fatalf("generate failed:%v, files:%v", resp.GetError(), len(resp.File))
+	}
+	if !strings.Contains(resp.File[0].GetContent(), `"github.com/fengyuqin/kungfu/v2/protoc-gen-kungfu/kungfu"`) {
+		t.Fatalf("generated pb.go should import options package:\n%s", resp.File[0].GetContent())
+	}
+	//放在模块内以使用本仓库的依赖
+	if err = os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("testdata", "hall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("testdata")
+	defer os.RemoveAll(dir)
+	for _, f := range resp.File {
+		if err = os.WriteFile(filepath.Join(dir, filepath.Base(f.GetName())), []byte(f.GetContent()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(goBin, "build", "./"+filepath.ToSlash(dir))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code not compiled:%v\n%s", err, out)
+	}
+}
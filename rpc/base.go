@@ -13,6 +13,10 @@
 package rpc
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/fengyuqin/kungfu/v2/config"
 	"github.com/fengyuqin/kungfu/v2/discover"
 	"github.com/fengyuqin/kungfu/v2/logger"
@@ -26,6 +30,10 @@ type ServerBase struct {
 	selfEventHandler      CallbackFunc
 	broadcastEventHandler CallbackFunc
 	innerMsgHandler       MsgHandler
+	interceptors          []Interceptor
+	clientInterceptors    []ClientInterceptor
+	metrics               *RpcMetrics
+	idempotency           *idempotency
 	plugins               []ServerPlugin
 }
 
@@ -38,13 +46,104 @@ func NewServerBase(s *treaty.Server, options ...Option) *ServerBase {
 	for _, option := range options {
 		option(server)
 	}
+	server.useInterceptors()
+	server.useIdempotency()
 	return server
 }
 
+// useInterceptors 将拦截器挂载到支持拦截器的消息处理器
+func (s *ServerBase) useInterceptors() {
+	if len(s.interceptors) < 1 {
+		return
+	}
+	if h, ok := s.innerMsgHandler.(interface{ Use(...Interceptor) }); ok {
+		h.Use(s.interceptors...)
+	} else {
+		logger.Errorf("inner msg handler not support interceptors:%T", s.innerMsgHandler)
+	}
+}
+
+// defMetricsOnce 默认rpc为进程共享,只由第一个调用UseMetrics的服务统计其出站调用
+var defMetricsOnce sync.Once
+
+// UseMetrics 统计本服务rpc及默认rpc的出站调用和消息处理器的入站处理,需在Init之后调用,
+// 默认rpc的出站调用只计入进程内第一个启用统计的服务
+func (s *ServerBase) UseMetrics(metrics *RpcMetrics) {
+	s.metrics = metrics
+	metrics.installClient(s.Rpc)
+	defMetricsOnce.Do(func() {
+		defRpcInit()
+		if defRpc != s.Rpc {
+			metrics.installClient(defRpc)
+		}
+	})
+	s.useMetrics()
+}
+
+func (s *ServerBase) useIdempotency() {
+	if s.idempotency == nil {
+		return
+	}
+	if h, ok := s.innerMsgHandler.(interface {
+		UseIdempotency(IdempotencyStore, time.Duration)
+	}); ok {
+		h.UseIdempotency(s.idempotency.store, s.idempotency.ttl)
+	} else {
+		logger.Errorf("inner msg handler not support idempotency:%T", s.innerMsgHandler)
+	}
+}
+
+func (s *ServerBase) useMetrics() {
+	if s.metrics == nil {
+		return
+	}
+	if h, ok := s.innerMsgHandler.(interface{ UseMetrics(*RpcMetrics) }); ok {
+		h.UseMetrics(s.metrics)
+	} else {
+		logger.Errorf("inner msg handler not support metrics:%T", s.innerMsgHandler)
+	}
+}
+
 func (s *ServerBase) Register(msgId int32, v any) {
 	s.innerMsgHandler.Register(msgId, v)
 }
 
+// RegisterStream 注册流式处理器,消息处理器需支持流式
+func (s *ServerBase) RegisterStream(msgId int32, v any) {
+	if h, ok := s.innerMsgHandler.(interface{ RegisterStream(int32, any) }); ok {
+		h.RegisterStream(msgId, v)
+	} else {
+		logger.Errorf("inner msg handler not support stream:%T", s.innerMsgHandler)
+	}
+}
+
+// RegisterService 注册结构体的全部处理方法,消息处理器需支持结构体注册
+func (s *ServerBase) RegisterService(v any, opts ...ServiceOption) error {
+	h, ok := s.innerMsgHandler.(interface {
+		RegisterService(any, ...ServiceOption) error
+	})
+	if !ok {
+		return fmt.Errorf("inner msg handler not support service:%T", s.innerMsgHandler)
+	}
+	return h.RegisterService(v, opts...)
+}
+
+// SetSignKeys 运行时轮换本服务rpc及默认rpc的签名key,同时更新rpc配置,按Signer.SetKeys的顺序分步下发
+func (s *ServerBase) SetSignKeys(keys []string) error {
+	if err := setSignKeys(s.Rpc, keys); err != nil {
+		return err
+	}
+	if defRpc != nil && defRpc != s.Rpc {
+		if err := setSignKeys(defRpc, keys); err != nil {
+			return err
+		}
+	}
+	cfg := config.GetRpcConf()
+	cfg.SignKeys = keys
+	config.SetRpcConf(cfg)
+	return nil
+}
+
 func (s *ServerBase) AddPlugin(plugin ServerPlugin) {
 	s.plugins = append(s.plugins, plugin)
 }
@@ -59,6 +158,9 @@ func (s *ServerBase) SetBroadcastEventHandler(handler CallbackFunc) {
 
 func (s *ServerBase) SetInnerMsgHandler(handler MsgHandler) {
 	s.innerMsgHandler = handler
+	s.useInterceptors()
+	s.useIdempotency()
+	s.useMetrics()
 }
 
 func (s *ServerBase) Init() {
@@ -73,6 +175,7 @@ func (s *ServerBase) Init() {
 	}
 	//初始化rpc服务
 	s.Rpc = NewRpcServer(config.GetRpcConf(), s.Server)
+	s.Rpc.UseClientInterceptors(s.clientInterceptors...)
 	//订阅创建
 	s.SubBuilder = NewRssBuilder(s.Server)
 	//plugins
@@ -152,8 +255,7 @@ func (s *ServerBase) Shutdown() {
 func (s *ServerBase) HandleSelfEvent(req *MsgRpc) []byte {
 	resp, err := s.innerMsgHandler.DealMsg(CodeTypeProto, s.Rpc, req)
 	if err != nil {
-		logger.Error(err)
-		return nil
+		return s.handleError(CodeTypeProto, req, err)
 	}
 	return resp
 }
@@ -161,12 +263,20 @@ func (s *ServerBase) HandleSelfEvent(req *MsgRpc) []byte {
 func (s *ServerBase) HandleBroadcastEvent(req *MsgRpc) []byte {
 	resp, err := s.innerMsgHandler.DealMsg(CodeTypeProto, s.Rpc, req)
 	if err != nil {
-		logger.Error(err)
-		return nil
+		return s.handleError(CodeTypeProto, req, err)
 	}
 	return resp
 }
 
+// handleError 请求、流式请求及持久化消息回复错误帧,其他消息仅记录日志
+func (s *ServerBase) handleError(codeType string, req *MsgRpc, err error) []byte {
+	logger.Error(err)
+	if req.MsgType != MsgTypeRequest && req.MsgType != MsgTypeStream && !req.Durable() {
+		return nil
+	}
+	return s.Rpc.ResponseError(codeType, err)
+}
+
 func (s *ServerBase) ServerMaintain(req *treaty.ServerMaintainReq) {
 	logger.Warnf("ServerMaintain notice:%+v", req)
 	serverId, reqState := req.ServerId, req.ReqState
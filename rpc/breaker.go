@@ -0,0 +1,210 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/discover"
+	"github.com/fengyuqin/kungfu/v2/logger"
+)
+
+// ErrCircuitOpen 目标熔断中,调用未发送
+var ErrCircuitOpen = errors.New("rpc circuit breaker open")
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 10 * time.Second
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota //正常调用
+	BreakerOpen                         //熔断,调用直接失败
+	BreakerHalfOpen                     //冷却结束,放行一个探测调用
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+type breakerEntry struct {
+	state    BreakerState
+	failures int //连续失败次数
+	openedAt time.Time
+}
+
+// CircuitBreaker 按目标熔断,直连调用按ServerId,队列调用按服务器类型及队列,
+// 连续失败或超时达到阈值后熔断,冷却后放行一个探测调用,成功则恢复
+type CircuitBreaker struct {
+	Threshold int              //连续失败次数达到后熔断
+	Cooldown  time.Duration    //熔断持续时间
+	Finder    *discover.Finder //熔断期间将该服务器的用户路由到其他服务器,为空时不处理
+	entries   map[string]*breakerEntry
+	lock      sync.Mutex
+}
+
+type BreakerOption func(b *CircuitBreaker)
+
+func WithBreakerThreshold(threshold int) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.Threshold = threshold
+	}
+}
+func WithBreakerCooldown(cooldown time.Duration) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.Cooldown = cooldown
+	}
+}
+
+// WithBreakerFinder 一般为调用所用rpc的GetFinder()
+func WithBreakerFinder(finder *discover.Finder) BreakerOption {
+	return func(b *CircuitBreaker) {
+		b.Finder = finder
+	}
+}
+
+func NewCircuitBreaker(opts ...BreakerOption) *CircuitBreaker {
+	b := &CircuitBreaker{
+		Threshold: DefaultBreakerThreshold,
+		Cooldown:  DefaultBreakerCooldown,
+		entries:   make(map[string]*breakerEntry),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// breakerTarget 熔断目标及对应服务器,广播及直接发送不熔断
+func breakerTarget(method string, s ReqBuilder) (target, serverId string) {
+	switch method {
+	case MethodPublish, MethodRequest, MethodRequestStream:
+		if s.server != nil {
+			return "server:" + s.server.ServerId, s.server.ServerId
+		}
+	case MethodQueuePublish, MethodQueueRequest, MethodQueueRequestStream:
+		return "queue:" + path.Join(s.serverType, s.queue), ""
+	}
+	return "", ""
+}
+
+// isBreakerFailure 发送失败、超时及过载计为失败,业务错误及调用方取消不计
+func isBreakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if re, ok := AsRemoteError(err); ok {
+		return re.Code == ErrCodeExpired || re.Code == ErrCodeOverloaded
+	}
+	return true
+}
+
+// Interceptor 熔断拦截器
+func (b *CircuitBreaker) Interceptor() ClientInterceptor {
+	return func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+		target, serverId := breakerTarget(method, s)
+		if len(target) < 1 {
+			return next(ctx, s)
+		}
+		probe, err := b.allow(target)
+		if err != nil {
+			return err
+		}
+		err = next(ctx, s)
+		b.record(target, serverId, probe, err)
+		return err
+	}
+}
+
+// allow 熔断中返回ErrCircuitOpen,冷却结束后第一个调用作为探测
+func (b *CircuitBreaker) allow(target string) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	entry, ok := b.entries[target]
+	if !ok || entry.state == BreakerClosed {
+		return false, nil
+	}
+	if entry.state == BreakerOpen && time.Since(entry.openedAt) >= b.Cooldown {
+		entry.state = BreakerHalfOpen
+		return true, nil
+	}
+	return false, fmt.Errorf("%w, target:%v", ErrCircuitOpen, target)
+}
+
+// record 记录调用结果,熔断后仅探测调用的结果改变状态
+func (b *CircuitBreaker) record(target, serverId string, probe bool, err error) {
+	failed := isBreakerFailure(err)
+	b.lock.Lock()
+	entry, ok := b.entries[target]
+	if !ok {
+		if !failed {
+			b.lock.Unlock()
+			return
+		}
+		entry = &breakerEntry{}
+		b.entries[target] = entry
+	}
+	var opened, closed bool
+	switch {
+	case entry.state == BreakerClosed && failed:
+		if entry.failures++; entry.failures >= b.Threshold {
+			entry.state, entry.openedAt, opened = BreakerOpen, time.Now(), true
+		}
+	case entry.state == BreakerClosed:
+		entry.failures = 0
+	case probe && errors.Is(err, context.Canceled):
+		//探测被调用方取消,下次调用重新探测
+		entry.state = BreakerOpen
+	case probe && failed:
+		entry.state, entry.openedAt = BreakerOpen, time.Now()
+	case probe:
+		delete(b.entries, target)
+		closed = true
+	}
+	b.lock.Unlock()
+	if opened {
+		logger.Warnf("rpc circuit breaker open, target:%v, failures:%v, err:%v", target, b.Threshold, err)
+		if b.Finder != nil && len(serverId) > 0 {
+			b.Finder.Exclude(serverId)
+		}
+	}
+	if closed {
+		logger.Infof("rpc circuit breaker closed, target:%v", target)
+		if b.Finder != nil && len(serverId) > 0 {
+			b.Finder.Include(serverId)
+		}
+	}
+}
+
+// State 目标当前的熔断状态,target为"server:ServerId"或"queue:服务器类型/队列"
+func (b *CircuitBreaker) State(target string) BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if entry, ok := b.entries[target]; ok {
+		return entry.state
+	}
+	return BreakerClosed
+}
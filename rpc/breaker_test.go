@@ -0,0 +1,84 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(WithBreakerThreshold(2), WithBreakerCooldown(50*time.Millisecond))
+	interceptor := breaker.Interceptor()
+	s := NewReqBuilder(&treaty.Server{ServerId: "game_1", ServerType: "game"}).Build()
+	target := "server:game_1"
+	var calls int
+	var result error
+	call := func() error {
+		return interceptor(context.Background(), MethodRequest, s, func(ctx context.Context, s ReqBuilder) error {
+			calls++
+			return result
+		})
+	}
+
+	//业务错误不计为失败
+	result = NewRemoteError(ErrCodeBadRequest, "bad request")
+	for i := 0; i < 3; i++ {
+		_ = call()
+	}
+	if state := breaker.State(target); state != BreakerClosed {
+		t.Fatalf("unexpected state:%v", state)
+	}
+
+	result = context.DeadlineExceeded
+	_ = call()
+	_ = call()
+	if state := breaker.State(target); state != BreakerOpen {
+		t.Fatalf("unexpected state:%v", state)
+	}
+	calls = 0
+	if err := call(); !errors.Is(err, ErrCircuitOpen) || calls != 0 {
+		t.Fatalf("breaker not fail fast, err:%v, calls:%v", err, calls)
+	}
+
+	//探测失败重新熔断
+	time.Sleep(60 * time.Millisecond)
+	if err := call(); !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Fatalf("probe not sent, err:%v, calls:%v", err, calls)
+	}
+	if err := call(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker not reopened, err:%v", err)
+	}
+
+	//探测成功恢复
+	time.Sleep(60 * time.Millisecond)
+	result = nil
+	if err := call(); err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.State(target); state != BreakerClosed {
+		t.Fatalf("unexpected state:%v", state)
+	}
+
+	//队列调用按队列熔断,广播不熔断
+	if target, _ := breakerTarget(MethodQueueRequest, s); target != "queue:game/"+DefaultQueue {
+		t.Fatalf("unexpected queue target:%v", target)
+	}
+	if target, _ := breakerTarget(MethodPublishBroadcast, s); target != "" {
+		t.Fatalf("unexpected broadcast target:%v", target)
+	}
+}
@@ -13,6 +13,7 @@
 package rpc
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/fengyuqin/kungfu/v2/logger"
@@ -31,12 +32,19 @@ type RssBuilder struct {
 	codeType    string
 	suffix      string
 	parallel    bool
+	workers     int          //并行处理的worker数量,0为DefaultWorkers,小于0表示不限制并发
+	queueDepth  int          //限制并发时每个队列的最大等待消息数,0为DefaultQueueDepth
+	orderKey    OrderKeyFunc //限制并发时相同顺序键的消息按序处理
 	dialTimeout time.Duration
 	//for rabbitmq
 	exName string //交换机名称
 	exType string //交换类型
 	rtKey  string //绑定key
-
+	//for jetstream
+	durable    bool          //持久化队列,仅QueueSubscribe有效
+	maxDeliver int           //最大投递次数,超过后转入死信
+	ackWait    time.Duration //确认超时,超时未确认重新投递
+	deadLetter string        //死信主题,默认为持久化主题.dlq
 }
 
 func NewRssBuilder(server *treaty.Server) *RssBuilder {
@@ -45,15 +53,17 @@ func NewRssBuilder(server *treaty.Server) *RssBuilder {
 		parallel = false
 	}
 	return &RssBuilder{
-		queue:    DefaultQueue,
-		server:   server,
-		callback: DefaultCallback,
-		codeType: CodeTypeProto,
-		suffix:   DefaultSuffix,
-		parallel: parallel,
-		exName:   DefaultExName,
-		exType:   DefaultExType,
-		rtKey:    DefaultRtKey,
+		queue:      DefaultQueue,
+		server:     server,
+		callback:   DefaultCallback,
+		codeType:   CodeTypeProto,
+		suffix:     DefaultSuffix,
+		parallel:   parallel,
+		exName:     DefaultExName,
+		exType:     DefaultExType,
+		rtKey:      DefaultRtKey,
+		maxDeliver: DefaultMaxDeliver,
+		ackWait:    DefaultAckWait,
 	}
 }
 func (r *RssBuilder) SetDialTimeout(d time.Duration) *RssBuilder {
@@ -96,22 +106,66 @@ func (r *RssBuilder) SetSuffix(suffix string) *RssBuilder {
 	r.suffix = suffix
 	return r
 }
+
+// SetParallel 并行处理订阅的消息,未设置workers时最多DefaultWorkers个同时处理
 func (r *RssBuilder) SetParallel(parallel bool) *RssBuilder {
 	r.parallel = parallel
 	return r
 }
 
+// SetWorkers 并行订阅最多workers个消息同时处理,超出的进入队列,队列满时拒绝,请求回复ErrCodeOverloaded,
+// 0时使用DefaultWorkers,小于0时不限制并发,每条消息一个协程,不能与顺序键同时使用
+func (r *RssBuilder) SetWorkers(workers int) *RssBuilder {
+	r.workers = workers
+	return r
+}
+func (r *RssBuilder) SetQueueDepth(depth int) *RssBuilder {
+	r.queueDepth = depth
+	return r
+}
+
+// SetOrderKey 相同顺序键的消息固定由同一worker按序处理,仅并行订阅有效,串行订阅本身有序
+func (r *RssBuilder) SetOrderKey(orderKey OrderKeyFunc) *RssBuilder {
+	r.orderKey = orderKey
+	return r
+}
+
+// SetDurable 队列订阅使用JetStream持久化消费,处理成功后确认
+func (r *RssBuilder) SetDurable(durable bool) *RssBuilder {
+	r.durable = durable
+	return r
+}
+func (r *RssBuilder) SetMaxDeliver(n int) *RssBuilder {
+	r.maxDeliver = n
+	return r
+}
+func (r *RssBuilder) SetAckWait(d time.Duration) *RssBuilder {
+	r.ackWait = d
+	return r
+}
+func (r *RssBuilder) SetDeadLetter(subject string) *RssBuilder {
+	r.deadLetter = subject
+	return r
+}
+
 func (r *RssBuilder) Build() RssBuilder {
 	return RssBuilder{
-		queue:    r.queue,
-		server:   r.server,
-		callback: r.callback,
-		codeType: r.codeType,
-		suffix:   r.suffix,
-		parallel: r.parallel,
-		exName:   r.exName,
-		exType:   r.exType,
-		rtKey:    r.rtKey,
+		queue:      r.queue,
+		server:     r.server,
+		callback:   r.callback,
+		codeType:   r.codeType,
+		suffix:     r.suffix,
+		parallel:   r.parallel,
+		workers:    r.workers,
+		queueDepth: r.queueDepth,
+		orderKey:   r.orderKey,
+		exName:     r.exName,
+		exType:     r.exType,
+		rtKey:      r.rtKey,
+		durable:    r.durable,
+		maxDeliver: r.maxDeliver,
+		ackWait:    r.ackWait,
+		deadLetter: r.deadLetter,
 	}
 }
 
@@ -125,10 +179,15 @@ type ReqBuilder struct {
 	resp        any
 	serverType  string
 	dialTimeout time.Duration
+	meta        Metadata
 	//for rabbitmq
 	exName string //交换机名称
 	exType string //交换类型
 	rtKey  string //绑定key
+	//for jetstream
+	durable bool //持久化队列发布,等待存储确认
+	//for scheduler
+	deliverAt time.Time //延迟投递时间
 }
 
 func NewReqBuilder(server *treaty.Server) *ReqBuilder {
@@ -211,6 +270,51 @@ func (r *ReqBuilder) SetDialTimeout(d time.Duration) *ReqBuilder {
 	r.dialTimeout = d
 	return r
 }
+func (r *ReqBuilder) SetMeta(key, val string) *ReqBuilder {
+	if r.meta == nil {
+		r.meta = make(Metadata)
+	}
+	r.meta[key] = val
+	return r
+}
+func (r *ReqBuilder) SetCaller(serverId string) *ReqBuilder {
+	return r.SetMeta(MetaCaller, serverId)
+}
+func (r *ReqBuilder) SetUid(uid int64) *ReqBuilder {
+	return r.SetMeta(MetaUid, strconv.FormatInt(uid, 10))
+}
+func (r *ReqBuilder) SetRequestId(reqId string) *ReqBuilder {
+	return r.SetMeta(MetaRequestId, reqId)
+}
+func (r *ReqBuilder) SetTraceId(traceId string) *ReqBuilder {
+	return r.SetMeta(MetaTraceId, traceId)
+}
+func (r *ReqBuilder) SetLocale(locale string) *ReqBuilder {
+	return r.SetMeta(MetaLocale, locale)
+}
+
+// SetIdempotencyKey 服务端启用幂等时,相同幂等键的请求只处理一次,重试返回首次的响应
+func (r *ReqBuilder) SetIdempotencyKey(key string) *ReqBuilder {
+	return r.SetMeta(MetaIdemKey, key)
+}
+
+// SetDurable 队列发布写入JetStream,消息在无队列成员在线时不丢失
+func (r *ReqBuilder) SetDurable(durable bool) *ReqBuilder {
+	r.durable = durable
+	return r
+}
+
+// SetDelay 延迟d后投递,仅单向消息有效,需安装Scheduler拦截器
+func (r *ReqBuilder) SetDelay(d time.Duration) *ReqBuilder {
+	r.deliverAt = time.Now().Add(d)
+	return r
+}
+
+// SetDeliverAt 在指定时间投递,仅单向消息有效,需安装Scheduler拦截器
+func (r *ReqBuilder) SetDeliverAt(t time.Time) *ReqBuilder {
+	r.deliverAt = t
+	return r
+}
 
 func (r *ReqBuilder) Build() ReqBuilder {
 	return ReqBuilder{
@@ -223,8 +327,37 @@ func (r *ReqBuilder) Build() ReqBuilder {
 		resp:        r.resp,
 		serverType:  r.serverType,
 		dialTimeout: r.dialTimeout,
+		meta:        r.meta.clone(),
 		exName:      r.exName,
 		exType:      r.exType,
 		rtKey:       r.rtKey,
+		durable:     r.durable,
+		deliverAt:   r.deliverAt,
+	}
+}
+
+func (r *ReqBuilder) GetMsgId() int32 {
+	return r.msgId
+}
+func (r *ReqBuilder) GetServer() *treaty.Server {
+	return r.server
+}
+func (r *ReqBuilder) GetServerType() string {
+	return r.serverType
+}
+func (r *ReqBuilder) GetQueue() string {
+	return r.queue
+}
+func (r *ReqBuilder) GetMeta() Metadata {
+	return r.meta
+}
+
+// message 生成待发送的rpc消息
+func (r *ReqBuilder) message(msgType MessageType) *MsgRpc {
+	return &MsgRpc{
+		MsgType: msgType,
+		MsgId:   r.msgId,
+		MsgData: r.req,
+		Meta:    r.meta,
 	}
 }
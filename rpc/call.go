@@ -0,0 +1,91 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+// CallOption 调整类型化调用的请求参数
+type CallOption func(b *ReqBuilder)
+
+// WithCallJson 使用json编码,发往json订阅
+func WithCallJson() CallOption {
+	return func(b *ReqBuilder) {
+		b.SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix)
+	}
+}
+
+func WithCallQueue(queue string) CallOption {
+	return func(b *ReqBuilder) {
+		b.SetQueue(queue)
+	}
+}
+
+func WithCallTimeout(d time.Duration) CallOption {
+	return func(b *ReqBuilder) {
+		b.SetDialTimeout(d)
+	}
+}
+
+func WithCallMeta(key, val string) CallOption {
+	return func(b *ReqBuilder) {
+		b.SetMeta(key, val)
+	}
+}
+
+// WithCallIdempotencyKey 设置幂等键,超时重试时使用相同的键
+func WithCallIdempotencyKey(key string) CallOption {
+	return func(b *ReqBuilder) {
+		b.SetIdempotencyKey(key)
+	}
+}
+
+// Call 使用默认rpc向指定服务器发起类型化请求,远程错误以*RemoteError返回
+func Call[Req, Resp any](ctx context.Context, server *treaty.Server, msgId int32, req *Req, opts ...CallOption) (*Resp, error) {
+	return CallWith[Req, Resp](ctx, defRpc, server, msgId, req, opts...)
+}
+
+// CallQueue 使用默认rpc向指定类型服务器的队列发起类型化请求
+func CallQueue[Req, Resp any](ctx context.Context, serverType string, msgId int32, req *Req, opts ...CallOption) (*Resp, error) {
+	return CallQueueWith[Req, Resp](ctx, defRpc, serverType, msgId, req, opts...)
+}
+
+// CallWith 使用指定rpc向指定服务器发起类型化请求
+func CallWith[Req, Resp any](ctx context.Context, r ServerRpc, server *treaty.Server, msgId int32, req *Req, opts ...CallOption) (*Resp, error) {
+	resp := new(Resp)
+	b := NewReqBuilder(server).SetMsgId(msgId).SetReq(req).SetResp(resp)
+	for _, opt := range opts {
+		opt(b)
+	}
+	if err := r.RequestWithContext(ctx, b.Build()); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CallQueueWith 使用指定rpc向指定类型服务器的队列发起类型化请求
+func CallQueueWith[Req, Resp any](ctx context.Context, r ServerRpc, serverType string, msgId int32, req *Req, opts ...CallOption) (*Resp, error) {
+	resp := new(Resp)
+	b := DefaultReqBuilder().SetServerType(serverType).SetMsgId(msgId).SetReq(req).SetResp(resp)
+	for _, opt := range opts {
+		opt(b)
+	}
+	if err := r.QueueRequestWithContext(ctx, b.Build()); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
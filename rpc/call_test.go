@@ -0,0 +1,41 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestCallWith(t *testing.T) {
+	r := newHandlerTestRpc()
+	h := NewHandler()
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		return &treaty.LoginResponse{Msg: req.Nickname}, nil
+	})
+	r.handler = h
+	server := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	resp, err := CallWith[treaty.LoginRequest, treaty.LoginResponse](context.Background(), r, server, 1, &treaty.LoginRequest{Uid: 1, Nickname: "jason"})
+	if err != nil || resp.Msg != "jason" {
+		t.Fatalf("call failed, err:%v, resp:%+v", err, resp)
+	}
+	_, err = CallQueueWith[treaty.LoginRequest, treaty.LoginResponse](context.Background(), r, "backend", 1, &treaty.LoginRequest{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != 1001 {
+		t.Fatalf("want remote error, got:%v", err)
+	}
+}
@@ -12,6 +12,12 @@
 
 package rpc
 
+import (
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+)
+
 const (
 	Balancer  = "balancer"
 	Connector = "connector"
@@ -31,6 +37,14 @@ const (
 	JsonSuffix    = "json"
 )
 const (
-	CodeTypeJson  = "json"
-	CodeTypeProto = "proto"
+	DefaultMaxDeliver = 5                //持久化队列默认最大投递次数
+	DefaultAckWait    = 30 * time.Second //持久化队列默认确认超时
+)
+
+// 内置编码类型,其他在serialize中注册的序列化名称同样可用
+const (
+	CodeTypeJson      = serialize.Json
+	CodeTypeProto     = serialize.Proto
+	CodeTypeMsgpack   = serialize.Msgpack
+	CodeTypeProtoJson = serialize.ProtoJson
 )
@@ -0,0 +1,85 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// HeaderTimeout 调用方剩余超时(纳秒),随传输层消息头传递给处理方,
+// 处理方按本机时钟重建截止时间,不受服务器间时钟偏差影响
+const HeaderTimeout = "Kungfu-Timeout"
+
+// withDialTimeout 调用方未设置截止时间时使用dialTimeout作为超时
+func withDialTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutNano 获取上下文的剩余超时,已过期时返回1纳秒,处理方收到即过期
+func timeoutNano(ctx context.Context) (int64, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	if remain := time.Until(deadline); remain > 0 {
+		return int64(remain), true
+	}
+	return 1, true
+}
+
+// deadlineNano 获取上下文的截止时间,仅用于同进程传递
+func deadlineNano(ctx context.Context) (int64, bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline.UnixNano(), true
+	}
+	return 0, false
+}
+
+// parseNano 解析消息头中的纳秒值
+func parseNano(v any) (int64, bool) {
+	var nano int64
+	switch d := v.(type) {
+	case int64:
+		nano = d
+	case string:
+		n, err := strconv.ParseInt(d, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		nano = n
+	default:
+		return 0, false
+	}
+	return nano, nano > 0
+}
+
+// msgContext 根据调用方剩余超时生成处理消息的上下文
+func msgContext(v any) (context.Context, context.CancelFunc) {
+	if timeout, ok := parseNano(v); ok {
+		return context.WithTimeout(context.Background(), time.Duration(timeout))
+	}
+	return context.WithCancel(context.Background())
+}
+
+// localContext 根据同进程调用方的截止时间生成处理消息的上下文
+func localContext(deadline int64) (context.Context, context.CancelFunc) {
+	if deadline > 0 {
+		return context.WithDeadline(context.Background(), time.Unix(0, deadline))
+	}
+	return context.WithCancel(context.Background())
+}
@@ -0,0 +1,63 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMsgContextTimeout(t *testing.T) {
+	ctx, cancel := withDialTimeout(context.Background(), time.Minute)
+	defer cancel()
+	nano, ok := timeoutNano(ctx)
+	if !ok || nano <= 0 || nano > int64(time.Minute) {
+		t.Fatalf("timeout not set, got:%v", nano)
+	}
+	for _, v := range []any{nano, strconv.FormatInt(nano, 10)} {
+		begin := time.Now()
+		msgCtx, msgCancel := msgContext(v)
+		deadline, ok := msgCtx.Deadline()
+		msgCancel()
+		//按本机时钟重建,与调用方的绝对时间无关
+		if !ok || deadline.Before(begin.Add(time.Duration(nano))) || deadline.After(time.Now().Add(time.Duration(nano))) {
+			t.Fatalf("deadline not rebuilt from timeout, timeout:%v, got:%v", nano, deadline)
+		}
+	}
+	msgCtx, msgCancel := msgContext("")
+	defer msgCancel()
+	if _, ok := msgCtx.Deadline(); ok {
+		t.Fatal("empty header should not have deadline")
+	}
+}
+
+func TestTimeoutNanoExpired(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if nano, ok := timeoutNano(ctx); !ok || nano != 1 {
+		t.Fatalf("expired context should send minimal timeout, got:%v", nano)
+	}
+}
+
+func TestWithDialTimeoutKeepCallerDeadline(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	ctx, cancel2 := withDialTimeout(parent, time.Second)
+	defer cancel2()
+	want, _ := parent.Deadline()
+	if got, _ := ctx.Deadline(); !got.Equal(want) {
+		t.Fatalf("caller deadline overridden, want:%v, got:%v", want, got)
+	}
+}
@@ -0,0 +1,366 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrDeadLetterNoRoute  = errors.New("dead letter subject not subscribed")
+)
+
+const (
+	DefaultDeadLetterKey    = "rpc_dead_letter"
+	DefaultDeadLetterMaxLen = 10000
+
+	DeadReasonDecode = "decode" //消息解码失败
+	DeadReasonPanic  = "panic"  //处理器panic
+)
+
+// DeadLetter 处理失败的rpc消息,保留原始帧,可重新投递
+type DeadLetter struct {
+	Id        string `json:"id"`
+	Transport string `json:"transport"`
+	Exchange  string `json:"exchange,omitempty"` //rabbitmq交换机
+	Subject   string `json:"subject"`            //nats主题或rabbitmq路由键
+	Frame     []byte `json:"frame"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error"`
+	Stack     string `json:"stack,omitempty"`
+	Time      int64  `json:"time"` //毫秒时间戳
+}
+
+// DeadLetterSink 死信写入
+type DeadLetterSink interface {
+	Put(letter *DeadLetter) error
+}
+
+// DeadLetterQueue 可查看及删除的死信存储,用于管理接口
+type DeadLetterQueue interface {
+	DeadLetterSink
+	List(after string, limit int64) ([]*DeadLetter, error) //按时间倒序,after为上一页最后一条的id,为空时从最新开始
+	Get(id string) (*DeadLetter, error)
+	Delete(id string) error
+}
+
+// DeadLetterRpc 支持死信的rpc实现,目前为NatsRpc及RabbitMqRpc
+type DeadLetterRpc interface {
+	UseDeadLetter(sink DeadLetterSink)
+	Replay(ctx context.Context, letter *DeadLetter) error //由本实例的订阅重新处理,处理成功返回nil,请求的回复将被丢弃
+}
+
+// deadLetterRoute 本实例的订阅,重新处理死信时直接调用
+type deadLetterRoute struct {
+	callback CallbackFunc
+	coder    EncoderRpc
+}
+
+// deadLetterBox 死信写入及重新处理,由rpc实现内嵌
+type deadLetterBox struct {
+	sink   DeadLetterSink
+	routes sync.Map //exchange及subject到*deadLetterRoute
+}
+
+func deadLetterRouteKey(exchange, subject string) string {
+	return exchange + "/" + subject
+}
+
+// addDeadLetterRoute 记录订阅,exchange及subject与写入死信时一致
+func (b *deadLetterBox) addDeadLetterRoute(exchange, subject string, s RssBuilder, coder EncoderRpc) {
+	b.routes.Store(deadLetterRouteKey(exchange, subject), &deadLetterRoute{callback: s.callback, coder: coder})
+}
+
+// Replay 由本实例订阅该主题的处理函数同步处理原始帧,签名仅校验不视为重放,
+// panic及错误帧视为失败,本实例未订阅该主题时返回ErrDeadLetterNoRoute
+func (b *deadLetterBox) Replay(ctx context.Context, letter *DeadLetter) error {
+	v, ok := b.routes.Load(deadLetterRouteKey(letter.Exchange, letter.Subject))
+	if !ok {
+		return fmt.Errorf("%w:%v", ErrDeadLetterNoRoute, letter.Subject)
+	}
+	route := v.(*deadLetterRoute)
+	req := &MsgRpc{}
+	if err := decodeStoredMsg(route.coder, letter.Frame, req); err != nil {
+		return err
+	}
+	return durableCall(route.callback, req.WithContext(ctx), route.coder)
+}
+
+// UseDeadLetter 解码失败及处理器panic的消息写入sink,需在订阅前设置
+func (b *deadLetterBox) UseDeadLetter(sink DeadLetterSink) {
+	b.sink = sink
+}
+
+func (b *deadLetterBox) putDeadLetter(transport, exchange, subject string, frame []byte, reason, errMsg string, stack []byte) {
+	if b.sink == nil {
+		return
+	}
+	letter := &DeadLetter{
+		Id:        uuid.NewString(),
+		Transport: transport,
+		Exchange:  exchange,
+		Subject:   subject,
+		Frame:     frame,
+		Reason:    reason,
+		Error:     errMsg,
+		Stack:     string(stack),
+		Time:      time.Now().UnixMilli(),
+	}
+	if err := b.sink.Put(letter); err != nil {
+		logger.Errorf("rpc dead letter put failed, subject:%v, reason:%v, err:%v", subject, reason, err)
+	}
+}
+
+// guardDeadLetter 须直接defer调用,处理器panic时写入死信后继续panic,由外层SafeRun记录
+func (b *deadLetterBox) guardDeadLetter(transport, exchange, subject string, frame []byte) {
+	if b.sink == nil {
+		return
+	}
+	if x := recover(); x != nil {
+		b.putDeadLetter(transport, exchange, subject, frame, DeadReasonPanic, fmt.Sprint(x), debug.Stack())
+		panic(x)
+	}
+}
+
+// DeadLetterStore 死信列表存储,stores.StoreKeeper满足该接口
+type DeadLetterStore interface {
+	LPush(key string, values ...any) error
+	LTrim(key string, start, stop int64) error
+	LRangeRaw(key string, start, stop int64) ([][]byte, error)
+	LRemRaw(key string, count int64, value []byte) (int64, error)
+}
+
+// RedisDeadLetters 死信保存在redis列表,超过MaxLen时丢弃最早的
+type RedisDeadLetters struct {
+	Store  DeadLetterStore
+	Key    string
+	MaxLen int64
+}
+
+func NewRedisDeadLetters(store DeadLetterStore) *RedisDeadLetters {
+	return &RedisDeadLetters{
+		Store:  store,
+		Key:    DefaultDeadLetterKey,
+		MaxLen: DefaultDeadLetterMaxLen,
+	}
+}
+
+func (d *RedisDeadLetters) Put(letter *DeadLetter) error {
+	if err := d.Store.LPush(d.Key, letter); err != nil {
+		return err
+	}
+	if d.MaxLen > 0 {
+		return d.Store.LTrim(d.Key, 0, d.MaxLen-1)
+	}
+	return nil
+}
+
+// List 按id翻页,新写入的死信插入列表头部,按偏移翻页会错位
+func (d *RedisDeadLetters) List(after string, limit int64) ([]*DeadLetter, error) {
+	res := make([]*DeadLetter, 0, limit)
+	seen := make(map[string]struct{})
+	found := len(after) < 1
+	err := d.scan(func(letter *DeadLetter, raw []byte) bool {
+		if !found {
+			found = letter.Id == after
+			return true
+		}
+		//翻页期间有新写入时,上一页末尾的死信会再次读到
+		if _, ok := seen[letter.Id]; ok {
+			return true
+		}
+		seen[letter.Id] = struct{}{}
+		res = append(res, letter)
+		return int64(len(res)) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrDeadLetterNotFound
+	}
+	return res, nil
+}
+
+// scan 从最新开始逐页遍历死信,fn返回false时停止,新写入只会使已读的死信后移,不会遗漏
+func (d *RedisDeadLetters) scan(fn func(letter *DeadLetter, raw []byte) bool) error {
+	const page = 100
+	for offset := int64(0); ; offset += page {
+		items, err := d.Store.LRangeRaw(d.Key, offset, offset+page-1)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			letter := &DeadLetter{}
+			if err = json.Unmarshal(item, letter); err != nil {
+				logger.Errorf("rpc dead letter invalid, key:%v, err:%v", d.Key, err)
+				continue
+			}
+			if !fn(letter, item) {
+				return nil
+			}
+		}
+		if len(items) < page {
+			return nil
+		}
+	}
+}
+
+// find 查找死信,返回原始数据用于删除
+func (d *RedisDeadLetters) find(id string) (*DeadLetter, []byte, error) {
+	var res *DeadLetter
+	var raw []byte
+	err := d.scan(func(letter *DeadLetter, item []byte) bool {
+		if letter.Id == id {
+			res, raw = letter, item
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if res == nil {
+		return nil, nil, ErrDeadLetterNotFound
+	}
+	return res, raw, nil
+}
+
+func (d *RedisDeadLetters) Get(id string) (*DeadLetter, error) {
+	letter, _, err := d.find(id)
+	return letter, err
+}
+
+func (d *RedisDeadLetters) Delete(id string) error {
+	_, raw, err := d.find(id)
+	if err != nil {
+		return err
+	}
+	_, err = d.Store.LRemRaw(d.Key, 1, raw)
+	return err
+}
+
+// DeadLetterAdmin 死信管理接口,挂载在prefix下:
+//
+//	GET    prefix?after=id&limit=20 查看死信,after为上一页最后一条的id
+//	POST   prefix/replay?id=xxx     重新处理,成功后删除
+//	DELETE prefix?id=xxx            删除
+//
+// 设置Token时请求需携带Authorization: Bearer <Token>,未设置时仅允许本机访问
+type DeadLetterAdmin struct {
+	Queue DeadLetterQueue
+	Rpc   DeadLetterRpc
+	Token string
+}
+
+type DeadLetterAdminOption func(a *DeadLetterAdmin)
+
+// WithDeadLetterToken 管理接口访问令牌,设置后允许远程访问
+func WithDeadLetterToken(token string) DeadLetterAdminOption {
+	return func(a *DeadLetterAdmin) {
+		a.Token = token
+	}
+}
+
+func NewDeadLetterAdmin(queue DeadLetterQueue, r DeadLetterRpc, opts ...DeadLetterAdminOption) *DeadLetterAdmin {
+	a := &DeadLetterAdmin{
+		Queue: queue,
+		Rpc:   r,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *DeadLetterAdmin) authorized(req *http.Request) bool {
+	if len(a.Token) > 0 {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (a *DeadLetterAdmin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !a.authorized(req) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	query := req.URL.Query()
+	var res any
+	var err error
+	switch {
+	case req.Method == http.MethodGet:
+		limit, _ := strconv.ParseInt(query.Get("limit"), 10, 64)
+		if limit < 1 {
+			limit = 20
+		}
+		res, err = a.Queue.List(query.Get("after"), limit)
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/replay"):
+		err = a.replay(req.Context(), query.Get("id"))
+	case req.Method == http.MethodDelete:
+		err = a.Queue.Delete(query.Get("id"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, ErrDeadLetterNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrDeadLetterNoRoute) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if res == nil {
+		res = map[string]bool{"ok": true}
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// replay 重新处理成功后删除,失败时保留
+func (a *DeadLetterAdmin) replay(ctx context.Context, id string) error {
+	letter, err := a.Queue.Get(id)
+	if err != nil {
+		return err
+	}
+	if err = a.Rpc.Replay(ctx, letter); err != nil {
+		return err
+	}
+	return a.Queue.Delete(id)
+}
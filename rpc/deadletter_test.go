@@ -0,0 +1,251 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+type memListStore struct {
+	list [][]byte
+}
+
+func (m *memListStore) LPush(key string, values ...any) error {
+	for _, v := range values {
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		m.list = append([][]byte{bs}, m.list...)
+	}
+	return nil
+}
+
+func (m *memListStore) LTrim(key string, start, stop int64) error {
+	if stop+1 < int64(len(m.list)) {
+		m.list = m.list[start : stop+1]
+	}
+	return nil
+}
+
+func (m *memListStore) LRangeRaw(key string, start, stop int64) ([][]byte, error) {
+	if start >= int64(len(m.list)) {
+		return nil, nil
+	}
+	if stop >= int64(len(m.list)) {
+		stop = int64(len(m.list)) - 1
+	}
+	return m.list[start : stop+1], nil
+}
+
+func (m *memListStore) LRemRaw(key string, count int64, value []byte) (int64, error) {
+	for i, item := range m.list {
+		if bytes.Equal(item, value) {
+			m.list = append(m.list[:i:i], m.list[i+1:]...)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+type replayTestRpc struct {
+	deadLetterBox
+	replayed []*DeadLetter
+}
+
+func (r *replayTestRpc) Replay(ctx context.Context, letter *DeadLetter) error {
+	r.replayed = append(r.replayed, letter)
+	return nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	queue := NewRedisDeadLetters(&memListStore{})
+	queue.MaxLen = 2
+	r := &replayTestRpc{}
+	r.UseDeadLetter(queue)
+
+	r.putDeadLetter("nats", "", "rpc/game", []byte("bad"), DeadReasonDecode, "decode failed", nil)
+	func() {
+		defer func() {
+			if x := recover(); x != "boom" {
+				t.Fatalf("panic not rethrown:%v", x)
+			}
+		}()
+		defer r.guardDeadLetter("nats", "", "rpc/game", []byte("frame"))
+		panic("boom")
+	}()
+	letters, err := queue.List("", 10)
+	if err != nil || len(letters) != 2 {
+		t.Fatalf("list failed, err:%v, letters:%v", err, len(letters))
+	}
+	panicked := letters[0]
+	if panicked.Reason != DeadReasonPanic || panicked.Error != "boom" || len(panicked.Stack) < 1 || string(panicked.Frame) != "frame" {
+		t.Fatalf("unexpected panic letter:%+v", panicked)
+	}
+	r.putDeadLetter("nats", "", "rpc/game", []byte("bad"), DeadReasonDecode, "decode failed", nil)
+	if letters, _ = queue.List("", 10); len(letters) != 2 {
+		t.Fatalf("max len not applied:%v", len(letters))
+	}
+
+	admin := httptest.NewServer(&DeadLetterAdmin{Queue: queue, Rpc: r})
+	defer admin.Close()
+	resp, err := http.Post(admin.URL+"/deadletters/replay?id="+panicked.Id, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(r.replayed) != 1 || r.replayed[0].Id != panicked.Id {
+		t.Fatalf("replay failed, status:%v, replayed:%v", resp.StatusCode, len(r.replayed))
+	}
+	if _, err = queue.Get(panicked.Id); err != ErrDeadLetterNotFound {
+		t.Fatalf("replayed letter not deleted:%v", err)
+	}
+	resp, err = http.Post(admin.URL+"/deadletters/replay?id="+panicked.Id, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status:%v", resp.StatusCode)
+	}
+}
+
+func TestDeadLetterReplaySigned(t *testing.T) {
+	queue := NewRedisDeadLetters(&memListStore{})
+	coder := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"key"}, 10*time.Millisecond)))
+	calls := 0
+	var got *treaty.LoginRequest
+	callback := func(req *MsgRpc) []byte {
+		calls++
+		switch calls {
+		case 1:
+			return responseError(coder, errors.New("busy"))
+		case 2:
+			panic("boom")
+		}
+		got = &treaty.LoginRequest{}
+		if err := coder.DecodeMsg(req.MsgData.([]byte), got); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}
+	box := &deadLetterBox{}
+	box.UseDeadLetter(queue)
+	box.addDeadLetterRoute("", "rpc/game", RssBuilder{callback: callback}, coder)
+
+	frame, err := coder.Encode(&MsgRpc{MsgType: MsgTypePublish, MsgId: 1, MsgData: &treaty.LoginRequest{Uid: 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//原始投递已记录nonce,且重新处理时签名已过期
+	if err = coder.Decode(frame, &MsgRpc{}); err != nil {
+		t.Fatal(err)
+	}
+	box.putDeadLetter("nats", "", "rpc/game", frame, DeadReasonPanic, "boom", nil)
+	box.putDeadLetter("nats", "", "rpc/other", frame, DeadReasonPanic, "boom", nil)
+	time.Sleep(20 * time.Millisecond)
+	letters, err := queue.List("", 10)
+	if err != nil || len(letters) != 2 {
+		t.Fatalf("list failed, err:%v, letters:%v", err, len(letters))
+	}
+	other, letter := letters[0], letters[1]
+
+	admin := httptest.NewServer(NewDeadLetterAdmin(queue, box))
+	defer admin.Close()
+	replay := func(id string) int {
+		resp, err := http.Post(admin.URL+"/deadletters/replay?id="+id, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	//错误帧及panic视为失败,死信保留
+	for i := 0; i < 2; i++ {
+		if code := replay(letter.Id); code != http.StatusInternalServerError {
+			t.Fatalf("want replay failed, status:%v", code)
+		}
+		if _, err = queue.Get(letter.Id); err != nil {
+			t.Fatalf("failed letter deleted:%v", err)
+		}
+	}
+	if code := replay(letter.Id); code != http.StatusOK || got == nil || got.Uid != 7 {
+		t.Fatalf("replay failed, status:%v, got:%+v", code, got)
+	}
+	if _, err = queue.Get(letter.Id); err != ErrDeadLetterNotFound {
+		t.Fatalf("replayed letter not deleted:%v", err)
+	}
+	if code := replay(other.Id); code != http.StatusConflict {
+		t.Fatalf("want no route, status:%v", code)
+	}
+}
+
+func TestDeadLetterAdminAuth(t *testing.T) {
+	queue := NewRedisDeadLetters(&memListStore{})
+	serve := func(admin *DeadLetterAdmin, remote, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/deadletters", nil)
+		req.RemoteAddr = remote
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w.Code
+	}
+	local := NewDeadLetterAdmin(queue, &deadLetterBox{})
+	if code := serve(local, "127.0.0.1:1234", ""); code != http.StatusOK {
+		t.Fatalf("loopback rejected:%v", code)
+	}
+	if code := serve(local, "192.0.2.1:1234", ""); code != http.StatusUnauthorized {
+		t.Fatalf("remote accepted without token:%v", code)
+	}
+	remote := NewDeadLetterAdmin(queue, &deadLetterBox{}, WithDeadLetterToken("secret"))
+	for token, want := range map[string]int{"": http.StatusUnauthorized, "bad": http.StatusUnauthorized, "secret": http.StatusOK} {
+		if code := serve(remote, "192.0.2.1:1234", token); code != want {
+			t.Fatalf("token:%q, want:%v, got:%v", token, want, code)
+		}
+	}
+}
+
+func TestDeadLetterPaging(t *testing.T) {
+	queue := NewRedisDeadLetters(&memListStore{})
+	box := &deadLetterBox{}
+	box.UseDeadLetter(queue)
+	for i := 0; i < 5; i++ {
+		box.putDeadLetter("nats", "", "rpc/game", nil, DeadReasonDecode, "", nil)
+	}
+	all, _ := queue.List("", 10)
+	page, err := queue.List("", 2)
+	if err != nil || len(page) != 2 || page[1].Id != all[1].Id {
+		t.Fatalf("first page failed, err:%v, page:%v", err, page)
+	}
+	//翻页期间写入新死信,下一页不错位
+	box.putDeadLetter("nats", "", "rpc/game", nil, DeadReasonDecode, "", nil)
+	page, err = queue.List(page[1].Id, 2)
+	if err != nil || len(page) != 2 || page[0].Id != all[2].Id || page[1].Id != all[3].Id {
+		t.Fatalf("next page shifted, err:%v, page:%v", err, page)
+	}
+	if _, err = queue.List("missing", 2); err != ErrDeadLetterNotFound {
+		t.Fatalf("want not found, got:%v", err)
+	}
+}
@@ -13,6 +13,7 @@
 package rpc
 
 import (
+	"context"
 	"sync"
 
 	"github.com/fengyuqin/kungfu/v2/config"
@@ -43,6 +44,12 @@ func DefRpcInit() {
 	defRpcInit()
 }
 
+// UseClientInterceptors 默认rpc出站调用拦截器
+func UseClientInterceptors(interceptors ...ClientInterceptor) {
+	defRpcInit()
+	defRpc.UseClientInterceptors(interceptors...)
+}
+
 func Publish(s ReqBuilder) error {
 	return defRpc.Publish(s)
 }
@@ -58,6 +65,27 @@ func Request(s ReqBuilder) error {
 func QueueRequest(s ReqBuilder) error {
 	return defRpc.QueueRequest(s)
 }
+func PublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return defRpc.PublishWithContext(ctx, s)
+}
+func QueuePublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return defRpc.QueuePublishWithContext(ctx, s)
+}
+func PublishBroadcastWithContext(ctx context.Context, s ReqBuilder) error {
+	return defRpc.PublishBroadcastWithContext(ctx, s)
+}
+func RequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return defRpc.RequestWithContext(ctx, s)
+}
+func QueueRequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return defRpc.QueueRequestWithContext(ctx, s)
+}
+func RequestStream(ctx context.Context, s ReqBuilder) (*StreamReader, error) {
+	return defRpc.RequestStream(ctx, s)
+}
+func QueueRequestStream(ctx context.Context, s ReqBuilder) (*StreamReader, error) {
+	return defRpc.QueueRequestStream(ctx, s)
+}
 
 func Find(serverType string, arg any, options ...discover.FilterOption) *treaty.Server {
 	return defRpc.Find(serverType, arg, options...)
@@ -66,3 +94,8 @@ func Find(serverType string, arg any, options ...discover.FilterOption) *treaty.
 func RemoveFindCache(arg any) {
 	defRpc.RemoveFindCache(arg)
 }
+
+func GetFinder() *discover.Finder {
+	defRpcInit()
+	return defRpc.GetFinder()
+}
@@ -0,0 +1,195 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/utils"
+)
+
+const (
+	DefaultQueueDepth = 1024
+	DefaultWorkers    = 256 //并行订阅未设置workers时的并发上限
+)
+
+// OrderKeyFunc 由请求元数据生成顺序键,相同键的消息按到达顺序依次处理,返回空表示不限制顺序
+type OrderKeyFunc func(meta Metadata) string
+
+// OrderByUid 同一用户的消息按顺序处理
+func OrderByUid(meta Metadata) string {
+	return meta.Get(MetaUid)
+}
+
+// dispatcher 订阅消息分发,串行订阅直接处理,并行订阅由固定数量的worker处理,队列满时拒绝,
+// workers为0时使用DefaultWorkers,小于0时不限制并发,每条消息一个协程
+type dispatcher struct {
+	parallel bool
+	orderKey OrderKeyFunc
+	shared   chan func()   //无顺序键的消息,任一空闲worker处理
+	ordered  []chan func() //有顺序键的消息,按键哈希固定到worker
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newDispatcher(s RssBuilder) (*dispatcher, error) {
+	d := &dispatcher{parallel: s.parallel, orderKey: s.orderKey, done: make(chan struct{})}
+	if !s.parallel {
+		return d, nil
+	}
+	workers := s.workers
+	if workers == 0 {
+		workers = DefaultWorkers
+	}
+	if workers < 0 {
+		if d.orderKey != nil {
+			return nil, errors.New("rpc order key requires bounded workers")
+		}
+		return d, nil
+	}
+	depth := s.queueDepth
+	if depth < 1 {
+		depth = DefaultQueueDepth
+	}
+	d.shared = make(chan func(), depth)
+	if d.orderKey != nil {
+		d.ordered = make([]chan func(), workers)
+		for i := range d.ordered {
+			d.ordered[i] = make(chan func(), depth)
+		}
+	}
+	for i := 0; i < workers; i++ {
+		go d.work(i)
+	}
+	return d, nil
+}
+
+func (d *dispatcher) work(i int) {
+	var ordered chan func()
+	if d.ordered != nil {
+		ordered = d.ordered[i]
+	}
+	for {
+		select {
+		case task := <-ordered:
+			utils.SafeRun(task)
+		case task := <-d.shared:
+			utils.SafeRun(task)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// stop 停止worker,队列中未处理的消息丢弃
+func (d *dispatcher) stop() {
+	d.once.Do(func() {
+		close(d.done)
+	})
+}
+
+// dispatch 分发消息,frame为rpc帧,用于读取顺序键,队列满时调用reject,已停止时丢弃
+func (d *dispatcher) dispatch(frame []byte, task func(), reject func()) {
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	if !d.parallel {
+		utils.SafeRun(task)
+		return
+	}
+	if d.shared == nil {
+		go utils.SafeRun(task)
+		return
+	}
+	queue := d.shared
+	if d.ordered != nil {
+		if key := d.orderKey(frameMeta(frame)); len(key) > 0 {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(key))
+			queue = d.ordered[h.Sum32()%uint32(len(d.ordered))]
+		}
+	}
+	select {
+	case queue <- task:
+	default:
+		utils.SafeRun(reject)
+	}
+}
+
+// dispatcherSet rpc实现创建的分发器,关闭rpc时停止全部worker
+type dispatcherSet struct {
+	items []*dispatcher
+	lock  sync.Mutex
+}
+
+func (ds *dispatcherSet) add(s RssBuilder) (*dispatcher, error) {
+	d, err := newDispatcher(s)
+	if err != nil {
+		return nil, err
+	}
+	ds.lock.Lock()
+	ds.items = append(ds.items, d)
+	ds.lock.Unlock()
+	return d, nil
+}
+
+func (ds *dispatcherSet) stop() {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+	for _, d := range ds.items {
+		d.stop()
+	}
+	ds.items = nil
+}
+
+// frameMeta 不解码消息体读取帧的元数据,仅用于分发,签名在处理时校验
+func frameMeta(frame []byte) Metadata {
+	head, length, err := frameHead(frame)
+	if err != nil || frame[3]&msgFlagMeta == 0 {
+		return nil
+	}
+	data := frame[head:length]
+	if frame[3]&msgFlagSign != 0 {
+		if len(data) < signLength {
+			return nil
+		}
+		data = data[signLength:]
+	}
+	meta, _, err := decodeMetadata(data)
+	if err != nil {
+		return nil
+	}
+	return meta
+}
+
+// overloadResponse 订阅过载时请求及流式请求回复过载错误帧,其他消息丢弃,
+// 只读取帧头,不解压消息体也不校验签名,避免占用nonce使调用方的重试被判为重放
+func overloadResponse(coder EncoderRpc, frame []byte) []byte {
+	if _, _, err := frameHead(frame); err != nil {
+		logger.Error(err)
+		return nil
+	}
+	msgType := MessageType(frame[3] &^ msgFlagMask)
+	msgId := int32(binary.BigEndian.Uint32(frame[4:msgHeadLength]))
+	logger.Warnf("rpc subscription overloaded, msgType:%v, msgId:%v", msgType, msgId)
+	if msgType != MsgTypeRequest && msgType != MsgTypeStream {
+		return nil
+	}
+	return responseError(coder, RemoteErrorf(ErrCodeOverloaded, "server overloaded, msgId:%v", msgId))
+}
@@ -0,0 +1,138 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+)
+
+func TestDispatcherOrder(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"k"}, 0)))
+	d, err := newDispatcher(RssBuilder{parallel: true, workers: 4, queueDepth: 128, orderKey: OrderByUid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.stop()
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		seen = make(map[string][]int)
+	)
+	for i := 0; i < 100; i++ {
+		uid := strconv.Itoa(i % 3)
+		frame, err := coder.Encode(&MsgRpc{MsgType: MsgTypePublish, MsgId: 1, MsgData: "x", Meta: Metadata{MetaUid: uid}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		i := i
+		d.dispatch(frame, func() {
+			defer wg.Done()
+			lock.Lock()
+			seen[uid] = append(seen[uid], i)
+			lock.Unlock()
+		}, func() {
+			t.Error("unexpected reject")
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	for uid, list := range seen {
+		for j := 1; j < len(list); j++ {
+			if list[j] < list[j-1] {
+				t.Fatalf("uid:%v out of order:%v", uid, list)
+			}
+		}
+	}
+}
+
+func TestDispatcherOverload(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer())
+	d, err := newDispatcher(RssBuilder{parallel: true, workers: 1, queueDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.stop()
+	frame, err := coder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 9, MsgData: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	d.dispatch(frame, func() {
+		close(started)
+		<-release
+	}, nil)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("worker not started")
+	}
+	rejected := 0
+	for i := 0; i < 2; i++ {
+		d.dispatch(frame, func() {}, func() {
+			rejected++
+		})
+	}
+	if rejected != 1 {
+		t.Fatalf("want 1 rejected, got:%v", rejected)
+	}
+	//请求回复过载错误,单向消息丢弃
+	err = coder.Decode(overloadResponse(coder, frame), &MsgRpc{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeOverloaded {
+		t.Fatalf("want overloaded error, got:%v", err)
+	}
+	publish, err := coder.Encode(&MsgRpc{MsgType: MsgTypePublish, MsgId: 9, MsgData: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := overloadResponse(coder, publish); resp != nil {
+		t.Fatal("publish should not reply")
+	}
+}
+
+func TestOverloadResponseKeepNonce(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"k"}, 0)))
+	frame, err := coder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 9, MsgData: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := overloadResponse(coder, frame); resp == nil {
+		t.Fatal("request should reply overloaded")
+	}
+	//过载拒绝不占用nonce,重试的同一帧仍可处理
+	if err = coder.Decode(frame, &MsgRpc{}); err != nil {
+		t.Fatalf("rejected frame should still decode, err:%v", err)
+	}
+}
+
+func TestDispatcherDefaults(t *testing.T) {
+	d, err := newDispatcher(RssBuilder{parallel: true, orderKey: OrderByUid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.shared == nil || len(d.ordered) != DefaultWorkers {
+		t.Fatal("parallel subscription should use default worker pool")
+	}
+	d.stop()
+	//停止后消息丢弃
+	d.dispatch(nil, func() { t.Error("stopped dispatcher should drop task") }, nil)
+	if _, err = newDispatcher(RssBuilder{parallel: true, workers: -1, orderKey: OrderByUid}); err == nil {
+		t.Fatal("order key without bounded workers should fail")
+	}
+}
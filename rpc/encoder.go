@@ -13,7 +13,14 @@
 package rpc
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"sync"
 
 	"github.com/fengyuqin/kungfu/v2/logger"
 	"github.com/fengyuqin/kungfu/v2/serialize"
@@ -24,16 +31,29 @@ type MessageType byte
 
 // Message types
 const (
-	MsgTypeRequest  MessageType = 0x00
-	MsgTypePublish              = 0x01
-	MsgTypeResponse             = 0x02
+	MsgTypeRequest   MessageType = 0x00
+	MsgTypePublish               = 0x01
+	MsgTypeResponse              = 0x02
+	MsgTypeError                 = 0x03
+	MsgTypeStream                = 0x04 //流式请求及响应分片
+	MsgTypeStreamEnd             = 0x05 //流结束标记
 )
 const (
 	msgHeadLength = 0x08
+	msgFlagMask   = 0xF0 //type高4位为标志位
+	msgFlagMeta   = 0x80 //携带元数据段
+	msgFlagZip    = 0x40 //消息体gzip压缩
+	msgFlagSign   = 0x20 //携带签名段
+	msgFlagLong   = 0x10 //帧长度超过3字节,头部后追加4字节长度
+	msgLongLength = 0x04
+	msgMaxShort   = 1<<24 - 1 //3字节长度上限
+	msgMaxLength  = 1 << 28   //帧长度上限
+	msgMaxUnzip   = 1 << 28   //解压后消息体上限
 )
 
 var (
-	ErrInvalidMessage = errors.New("invalid message")
+	ErrInvalidMessage  = errors.New("invalid message")
+	ErrMessageTooLarge = errors.New("message too large")
 )
 
 type EncoderRpc interface {
@@ -44,23 +64,138 @@ type EncoderRpc interface {
 	Response(v any) []byte
 }
 
+// ErrorEncoderRpc 可编码错误回复的编码器,EncoderRpc未实现时按通用错误帧编码
+type ErrorEncoderRpc interface {
+	ResponseError(err error) []byte
+}
+
+// responseError 编码错误回复,编码器未实现ErrorEncoderRpc时使用其Encode生成错误帧
+func responseError(coder EncoderRpc, err error) []byte {
+	if c, ok := coder.(ErrorEncoderRpc); ok {
+		return c.ResponseError(err)
+	}
+	res, err := coder.Encode(&MsgRpc{
+		MsgType: MsgTypeError,
+		MsgData: encodeRemoteError(toRemoteError(err)),
+	})
+	if err != nil {
+		logger.Error(err)
+		return nil
+	}
+	return res
+}
+
 type MsgRpc struct {
 	MsgType MessageType
 	MsgId   int32
 	MsgData any
+	Meta    Metadata
+	ctx     context.Context
+	durable bool
+	reply   func(data []byte) error //流式请求发送响应分片
+}
+
+// Context 消息处理上下文,携带调用方剩余的截止时间
+func (m *MsgRpc) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// WithContext 设置消息处理上下文
+func (m *MsgRpc) WithContext(ctx context.Context) *MsgRpc {
+	m.ctx = ctx
+	return m
+}
+
+// Durable 是否为持久化队列消息,处理失败时需返回错误帧以便重新投递
+func (m *MsgRpc) Durable() bool {
+	return m.durable
 }
 
 type DefaultRpcEncoder struct {
-	encoder serialize.Serializer
+	encoder           serialize.Serializer
+	compressThreshold int     //消息体超过该字节数时压缩,0表示不压缩
+	signer            *Signer //非空时签名发出的帧,并拒绝未签名的帧
+}
+
+type RpcEncoderOption func(r *DefaultRpcEncoder)
+
+// WithCompressThreshold 消息体超过threshold字节时压缩,压缩后不变小则发送原文
+func WithCompressThreshold(threshold int) RpcEncoderOption {
+	return func(r *DefaultRpcEncoder) {
+		r.compressThreshold = threshold
+	}
+}
+
+// WithSigner 使用HMAC签名,同一rpc的编码器应共享signer以共用nonce记录
+func WithSigner(signer *Signer) RpcEncoderOption {
+	return func(r *DefaultRpcEncoder) {
+		r.signer = signer
+	}
 }
 
-func NewRpcEncoder(encoder serialize.Serializer) *DefaultRpcEncoder {
-	return &DefaultRpcEncoder{encoder: encoder}
+func NewRpcEncoder(encoder serialize.Serializer, opts ...RpcEncoderOption) *DefaultRpcEncoder {
+	r := &DefaultRpcEncoder{encoder: encoder}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// newRpcCoders 各rpc实现默认的编码器,每个已注册的序列化对应同名编码类型
+func newRpcCoders(opts ...RpcEncoderOption) map[string]EncoderRpc {
+	coders := make(map[string]EncoderRpc)
+	for _, name := range serialize.Names() {
+		s, _ := serialize.Get(name)
+		coders[name] = NewRpcEncoder(s, opts...)
+	}
+	return coders
+}
+
+var zipWriters = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+func zipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zipWriters.Get().(*gzip.Writer)
+	defer zipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unzipData(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	res, err := io.ReadAll(io.LimitReader(r, msgMaxUnzip+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(res) > msgMaxUnzip {
+		return nil, ErrInvalidMessage
+	}
+	return res, nil
 }
 
 // Encode Protocol
-// --------<length>--------|--type--|----<MsgId>------|-<data>-
-// ----------3byte---------|-1 byte-|-----4 byte------|--------
+// --------<length>--------|--type--|----<MsgId>------|-<long length>-|-<sign>-|-<meta>-|-<data>-
+// ----------3byte---------|-1 byte-|-----4 byte------|----optional---|optional|optional|--------
+// type高位msgFlagSign置位时携带签名段,msgFlagMeta置位时携带元数据段,msgFlagZip置位时data为gzip压缩,均未置位时与旧协议一致
+// 帧长度超过16MB时msgFlagLong置位,length填0,头部后追加4字节完整长度,未超过时与旧协议一致
 func (r *DefaultRpcEncoder) Encode(rpcMsg *MsgRpc) ([]byte, error) {
 	var data []byte
 	var err error
@@ -75,31 +210,146 @@ func (r *DefaultRpcEncoder) Encode(rpcMsg *MsgRpc) ([]byte, error) {
 			return nil, err
 		}
 	}
+	msgType := byte(rpcMsg.MsgType)
+	var meta []byte
+	if len(rpcMsg.Meta) > 0 {
+		meta, err = encodeMetadata(rpcMsg.Meta)
+		if err != nil {
+			return nil, err
+		}
+		msgType |= msgFlagMeta
+	}
+	if r.compressThreshold > 0 && len(data) > r.compressThreshold {
+		zipped, err := zipData(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(zipped) < len(data) {
+			data = zipped
+			msgType |= msgFlagZip
+		}
+	}
+	signLen := 0
+	if r.signer != nil {
+		signLen = signLength
+		msgType |= msgFlagSign
+	}
 	//大端序
-	length := msgHeadLength + len(data)
-	buf := make([]byte, msgHeadLength)
-	buf[0] = byte((length >> 16) & 0xFF)
-	buf[1] = byte((length >> 8) & 0xFF)
-	buf[2] = byte(length & 0xFF)
-	buf[3] = byte(rpcMsg.MsgType)
+	head := msgHeadLength
+	length := head + signLen + len(meta) + len(data)
+	if length > msgMaxShort {
+		head += msgLongLength
+		length += msgLongLength
+		msgType |= msgFlagLong
+	}
+	if length > msgMaxLength {
+		return nil, fmt.Errorf("%w, msgId:%v, length:%v", ErrMessageTooLarge, rpcMsg.MsgId, length)
+	}
+	buf := make([]byte, head+signLen, length)
+	if msgType&msgFlagLong == 0 {
+		buf[0] = byte((length >> 16) & 0xFF)
+		buf[1] = byte((length >> 8) & 0xFF)
+		buf[2] = byte(length & 0xFF)
+	} else {
+		binary.BigEndian.PutUint32(buf[msgHeadLength:head], uint32(length))
+	}
+	buf[3] = msgType
 	buf[4] = byte((rpcMsg.MsgId >> 24) & 0xFF)
 	buf[5] = byte((rpcMsg.MsgId >> 16) & 0xFF)
 	buf[6] = byte((rpcMsg.MsgId >> 8) & 0xFF)
 	buf[7] = byte(rpcMsg.MsgId & 0xFF)
+	buf = append(buf, meta...)
 	buf = append(buf, data...)
+	if r.signer != nil {
+		if err = r.signer.sign(buf, head); err != nil {
+			return nil, err
+		}
+	}
 	return buf, nil
 }
 
 func (r *DefaultRpcEncoder) Decode(data []byte, rpcMsg *MsgRpc) error {
+	return r.decode(data, rpcMsg, true)
+}
+
+// decodeStored 解码持久化消息,重复投递不视为重放,仅校验签名
+func (r *DefaultRpcEncoder) decodeStored(data []byte, rpcMsg *MsgRpc) error {
+	return r.decode(data, rpcMsg, false)
+}
+
+// decodeStoredMsg 解码持久化或重新处理的消息,编码器不支持时按普通消息解码
+func decodeStoredMsg(coder EncoderRpc, data []byte, rpcMsg *MsgRpc) error {
+	if d, ok := coder.(interface {
+		decodeStored(data []byte, rpcMsg *MsgRpc) error
+	}); ok {
+		return d.decodeStored(data, rpcMsg)
+	}
+	return coder.Decode(data, rpcMsg)
+}
+
+// frameHead 解析帧头长度及帧长度,长度与数据不符时返回错误
+func frameHead(data []byte) (head, length int, err error) {
 	if len(data) < msgHeadLength {
-		return ErrInvalidMessage
+		return 0, 0, ErrInvalidMessage
+	}
+	head = msgHeadLength
+	if data[3]&msgFlagLong == 0 {
+		length = utils.BigBytesToInt(data[:3])
+	} else {
+		head += msgLongLength
+		if len(data) < head {
+			return 0, 0, ErrInvalidMessage
+		}
+		length = int(binary.BigEndian.Uint32(data[msgHeadLength:head]))
+	}
+	if length < head || length > len(data) || length > msgMaxLength {
+		return 0, 0, fmt.Errorf("%w, length:%v, received:%v", ErrInvalidMessage, length, len(data))
+	}
+	return head, length, nil
+}
+
+func (r *DefaultRpcEncoder) decode(data []byte, rpcMsg *MsgRpc, replay bool) error {
+	head, msgLength, err := frameHead(data)
+	if err != nil {
+		return err
 	}
-	msgLength := utils.BigBytesToInt(data[:3])
 	msgType := data[3]
 	msgId := utils.BigBytesToInt(data[4:8])
-	msgData := data[msgHeadLength:msgLength]
-	rpcMsg.MsgType = MessageType(msgType)
+	msgData := data[head:msgLength]
+	if msgType&msgFlagSign != 0 {
+		if len(msgData) < signLength {
+			return ErrInvalidMessage
+		}
+		if r.signer != nil {
+			if err := r.signer.verify(data[:msgLength], head, replay); err != nil {
+				return err
+			}
+		}
+		msgData = msgData[signLength:]
+	} else if r.signer != nil {
+		return ErrSignMissing
+	}
+	if msgType&msgFlagMeta != 0 {
+		meta, n, err := decodeMetadata(msgData)
+		if err != nil {
+			return err
+		}
+		rpcMsg.Meta = meta
+		msgData = msgData[n:]
+	}
+	if msgType&msgFlagZip != 0 {
+		unzipped, err := unzipData(msgData)
+		if err != nil {
+			return err
+		}
+		msgData = unzipped
+	}
+	rpcMsg.MsgType = MessageType(msgType &^ msgFlagMask)
 	rpcMsg.MsgId = int32(msgId)
+	//错误帧直接返回远程错误
+	if rpcMsg.MsgType == MsgTypeError {
+		return decodeRemoteError(msgData)
+	}
 	if rpcMsg.MsgData == nil {
 		rpcMsg.MsgData = msgData
 	} else {
@@ -132,3 +382,20 @@ func (r *DefaultRpcEncoder) Response(v any) []byte {
 	}
 	return res
 }
+
+// ResponseError 错误回复
+// --<length>--|--type(0x03)--|--<MsgId>--|--<code>--|-<msg>-
+// ---3byte----|----1 byte----|---4 byte--|--4 byte--|-------
+func (r *DefaultRpcEncoder) ResponseError(err error) []byte {
+	rpcMsg := &MsgRpc{
+		MsgType: MsgTypeError,
+		MsgId:   0,
+		MsgData: encodeRemoteError(toRemoteError(err)),
+	}
+	res, err := r.Encode(rpcMsg)
+	if err != nil {
+		logger.Error(err)
+		return nil
+	}
+	return res
+}
@@ -13,7 +13,9 @@
 package rpc
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -56,3 +58,147 @@ func TestDuration(t *testing.T) {
 	var a time.Duration
 	fmt.Println(a > 0)
 }
+
+func TestRpcEncoderError(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer())
+	data := coder.ResponseError(NewRemoteError(ErrCodeNotFound, "not found"))
+	err := coder.Decode(data, &MsgRpc{MsgData: &treaty.LoginResponse{}})
+	re, ok := AsRemoteError(err)
+	if !ok {
+		t.Fatalf("want remote error, got:%v", err)
+	}
+	if re.Code != ErrCodeNotFound || re.Msg != "not found" {
+		t.Fatalf("remote error not match:%+v", re)
+	}
+}
+
+// plainEncoder 未实现ErrorEncoderRpc的第三方编码器
+type plainEncoder struct {
+	EncoderRpc
+}
+
+func TestRpcEncoderErrorFallback(t *testing.T) {
+	coder := plainEncoder{NewRpcEncoder(serialize.NewJsonSerializer())}
+	if _, ok := EncoderRpc(coder).(ErrorEncoderRpc); ok {
+		t.Fatal("plain encoder should not implement ErrorEncoderRpc")
+	}
+	data := responseError(coder, NewRemoteError(ErrCodeNotFound, "not found"))
+	re, ok := AsRemoteError(coder.Decode(data, &MsgRpc{}))
+	if !ok || re.Code != ErrCodeNotFound || re.Msg != "not found" {
+		t.Fatalf("fallback error frame not match, got:%+v", re)
+	}
+}
+
+func TestRpcEncoderMeta(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer())
+	req := NewReqBuilder(nil).SetMsgId(1).SetReq(&treaty.LoginRequest{Uid: 1001}).
+		SetCaller("1001").SetUid(1001).SetTraceId("trace").Build()
+	eData, err := coder.Encode(req.message(MsgTypeRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &MsgRpc{MsgData: &treaty.LoginRequest{}}
+	if err = coder.Decode(eData, msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MsgType != MsgTypeRequest || msg.Meta.Caller() != "1001" || msg.Meta.Uid() != 1001 || msg.Meta.TraceId() != "trace" {
+		t.Fatalf("meta not match:%+v", msg)
+	}
+	if msg.MsgData.(*treaty.LoginRequest).Uid != 1001 {
+		t.Fatalf("data not match:%+v", msg.MsgData)
+	}
+	//无元数据时与旧协议一致
+	eData, err = coder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 1, MsgData: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eData[3] != byte(MsgTypeRequest) || string(eData[msgHeadLength:]) != "old" {
+		t.Fatalf("old frame not match:%v", eData)
+	}
+}
+
+func TestRpcEncoderCompress(t *testing.T) {
+	zipCoder := NewRpcEncoder(serialize.NewJsonSerializer(), WithCompressThreshold(64))
+	plainCoder := NewRpcEncoder(serialize.NewJsonSerializer())
+	req := &treaty.LoginRequest{Uid: 1, Nickname: strings.Repeat("kungfu", 100)}
+	zipped, err := zipCoder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 1, MsgData: req, Meta: Metadata{MetaTraceId: "t1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := plainCoder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 1, MsgData: req})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zipped[3]&msgFlagZip == 0 || len(zipped) >= len(plain) {
+		t.Fatalf("frame not compressed, zipped:%v, plain:%v", len(zipped), len(plain))
+	}
+	//压缩及未压缩帧均可被任意编码器解码
+	for _, coder := range []EncoderRpc{zipCoder, plainCoder} {
+		for _, data := range [][]byte{zipped, plain} {
+			res := &treaty.LoginRequest{}
+			msg := &MsgRpc{MsgData: res}
+			if err = coder.Decode(data, msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.MsgType != MsgTypeRequest || res.Nickname != req.Nickname {
+				t.Fatalf("decode not match:%+v", msg)
+			}
+		}
+	}
+	//小消息不压缩
+	small, err := zipCoder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 1, MsgData: &treaty.LoginRequest{Uid: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small[3]&msgFlagZip != 0 {
+		t.Fatal("small frame should not be compressed")
+	}
+}
+
+func TestRpcEncoderLongFrame(t *testing.T) {
+	signer := NewSigner([]string{"k1"}, 0)
+	for _, coder := range []*DefaultRpcEncoder{
+		NewRpcEncoder(serialize.NewJsonSerializer()),
+		NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(signer)),
+	} {
+		payload := strings.Repeat("k", msgMaxShort)
+		eData, err := coder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 7, MsgData: payload, Meta: Metadata{MetaTraceId: "t1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eData[3]&msgFlagLong == 0 {
+			t.Fatal("long frame flag not set")
+		}
+		msg := &MsgRpc{}
+		if err = coder.Decode(eData, msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.MsgId != 7 || msg.Meta.TraceId() != "t1" || len(msg.MsgData.([]byte)) != len(payload) {
+			t.Fatalf("long frame not match, msgId:%v, meta:%v", msg.MsgId, msg.Meta)
+		}
+	}
+	if _, err := NewRpcEncoder(serialize.NewJsonSerializer()).Encode(&MsgRpc{MsgData: make([]byte, msgMaxLength)}); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("want too large error, got:%v", err)
+	}
+}
+
+func TestRpcEncoderMalformed(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer())
+	eData, err := coder.Encode(&MsgRpc{MsgType: MsgTypeRequest, MsgId: 1, MsgData: "hello", Meta: Metadata{MetaTraceId: "t1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := append([]byte{0, 0, 0, byte(MsgTypeRequest) | msgFlagLong, 0, 0, 0, 1}, 0xFF, 0xFF, 0xFF, 0xFF)
+	for name, data := range map[string][]byte{
+		"empty":      nil,
+		"short head": eData[:msgHeadLength-1],
+		"truncated":  eData[:len(eData)-1],
+		"zero len":   append([]byte{0, 0, 0}, eData[3:]...),
+		"long head":  long[:msgHeadLength+2],
+		"long len":   long,
+	} {
+		if err = coder.Decode(data, &MsgRpc{}); !errors.Is(err, ErrInvalidMessage) {
+			t.Fatalf("%v: want invalid message, got:%v", name, err)
+		}
+	}
+}
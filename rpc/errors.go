@@ -0,0 +1,83 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 远程错误码
+const (
+	ErrCodeBadRequest int32 = 400 //请求解析失败
+	ErrCodeNotFound   int32 = 404 //msgId未注册
+	ErrCodeExpired    int32 = 408 //调用方已放弃等待
+	ErrCodeConflict   int32 = 409 //相同幂等键的请求正在处理
+	ErrCodeInternal   int32 = 500 //处理方内部错误
+	ErrCodeOverloaded int32 = 503 //处理方订阅队列已满
+)
+
+// RemoteError 处理方返回的错误,通过MsgTypeError帧传回调用方
+type RemoteError struct {
+	Code int32
+	Msg  string
+}
+
+func NewRemoteError(code int32, msg string) *RemoteError {
+	return &RemoteError{Code: code, Msg: msg}
+}
+
+func RemoteErrorf(code int32, format string, args ...any) *RemoteError {
+	return &RemoteError{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("rpc remote error, code:%v, msg:%v", e.Code, e.Msg)
+}
+
+// AsRemoteError 判断是否为远程错误
+func AsRemoteError(err error) (*RemoteError, bool) {
+	var re *RemoteError
+	if errors.As(err, &re) {
+		return re, true
+	}
+	return nil, false
+}
+
+// toRemoteError 非远程错误统一转为内部错误
+func toRemoteError(err error) *RemoteError {
+	if re, ok := AsRemoteError(err); ok {
+		return re
+	}
+	return NewRemoteError(ErrCodeInternal, err.Error())
+}
+
+// Error payload
+// ----<code>----|-<msg>-
+// ----4 byte----|-------
+func encodeRemoteError(e *RemoteError) []byte {
+	buf := make([]byte, 4, 4+len(e.Msg))
+	binary.BigEndian.PutUint32(buf, uint32(e.Code))
+	return append(buf, e.Msg...)
+}
+
+func decodeRemoteError(data []byte) *RemoteError {
+	if len(data) < 4 {
+		return NewRemoteError(ErrCodeInternal, string(data))
+	}
+	return &RemoteError{
+		Code: int32(binary.BigEndian.Uint32(data[:4])),
+		Msg:  string(data[4:]),
+	}
+}
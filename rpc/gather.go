@@ -0,0 +1,72 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/fengyuqin/kungfu/v2/discover"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+// GatherResult 广播请求结果,按服务器ID区分应答、失败及超时
+type GatherResult[Resp any] struct {
+	Answered map[string]*Resp //正常应答
+	Failed   map[string]error //返回错误或发送失败
+	TimedOut []string         //截止时间内未应答
+}
+
+// Complete 是否所有服务器都已应答
+func (g *GatherResult[Resp]) Complete() bool {
+	return len(g.Failed) == 0 && len(g.TimedOut) == 0
+}
+
+// Gather 使用默认rpc向指定类型的所有服务器发起请求并收集应答
+func Gather[Req, Resp any](ctx context.Context, serverType string, msgId int32, req *Req, opts ...CallOption) *GatherResult[Resp] {
+	return GatherWith[Req, Resp](ctx, defRpc, discover.GetServerTypeList(serverType), msgId, req, opts...)
+}
+
+// GatherWith 使用指定rpc并发请求所有服务器,每个请求共享调用截止时间
+func GatherWith[Req, Resp any](ctx context.Context, r ServerRpc, servers map[string]*treaty.Server, msgId int32, req *Req, opts ...CallOption) *GatherResult[Resp] {
+	res := &GatherResult[Resp]{
+		Answered: make(map[string]*Resp, len(servers)),
+		Failed:   make(map[string]error),
+	}
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+	)
+	for serverId, server := range servers {
+		wg.Add(1)
+		go func(serverId string, server *treaty.Server) {
+			defer wg.Done()
+			resp, err := CallWith[Req, Resp](ctx, r, server, msgId, req, opts...)
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err == nil:
+				res.Answered[serverId] = resp
+			case errors.Is(err, context.DeadlineExceeded):
+				res.TimedOut = append(res.TimedOut, serverId)
+			default:
+				res.Failed[serverId] = err
+			}
+		}(serverId, server)
+	}
+	wg.Wait()
+	sort.Strings(res.TimedOut)
+	return res
+}
@@ -0,0 +1,63 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestGatherWith(t *testing.T) {
+	bus := NewLocalBus()
+	var broadcast int32
+	s1 := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	s2 := &treaty.Server{ServerId: "1002", ServerType: "backend"}
+	s3 := &treaty.Server{ServerId: "1003", ServerType: "backend"}
+	s4 := &treaty.Server{ServerId: "1004", ServerType: "backend"}
+	defer newLocalTestServer(t, bus, s1, &broadcast).Close()
+	defer newLocalTestServer(t, bus, s2, &broadcast).Close()
+	//慢服务器
+	slow := NewRpcLocal(WithLocalBus(bus), WithLocalServer(s3))
+	defer slow.Close()
+	h := NewHandler()
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		time.Sleep(200 * time.Millisecond)
+		return &treaty.LoginResponse{Msg: s3.ServerId}, nil
+	})
+	callback := func(req *MsgRpc) []byte {
+		resp, _ := h.DealMsg(CodeTypeJson, slow, req)
+		return resp
+	}
+	if err := slow.Subscribe(NewRssBuilder(s3).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetCallback(callback).Build()); err != nil {
+		t.Fatal(err)
+	}
+	client := NewRpcLocal(WithLocalBus(bus))
+	servers := map[string]*treaty.Server{s1.ServerId: s1, s2.ServerId: s2, s3.ServerId: s3, s4.ServerId: s4}
+	res := GatherWith[treaty.LoginRequest, treaty.LoginResponse](context.Background(), client, servers, 1, &treaty.LoginRequest{Uid: 1}, WithCallJson(), WithCallTimeout(50*time.Millisecond))
+	if len(res.Answered) != 2 || res.Answered[s1.ServerId].Msg != s1.ServerId || res.Answered[s2.ServerId].Msg != s2.ServerId {
+		t.Fatalf("answered not match:%v", res.Answered)
+	}
+	if len(res.TimedOut) != 1 || res.TimedOut[0] != s3.ServerId {
+		t.Fatalf("timed out not match:%v", res.TimedOut)
+	}
+	if err := res.Failed[s4.ServerId]; len(res.Failed) != 1 || !errors.Is(err, ErrLocalNoResponders) {
+		t.Fatalf("failed not match:%v", res.Failed)
+	}
+	if res.Complete() {
+		t.Fatal("gather should not be complete")
+	}
+}
@@ -13,8 +13,9 @@
 package rpc
 
 import (
-	"fmt"
+	"context"
 	"reflect"
+	"time"
 
 	"github.com/fengyuqin/kungfu/v2/logger"
 )
@@ -24,13 +25,24 @@ type MsgHandler interface {
 	DealMsg(codeType string, server ServerRpc, req *MsgRpc) ([]byte, error)
 }
 
+var (
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfStream  = reflect.TypeOf((*StreamSender)(nil))
+)
+
 type HandlerItem struct {
 	MsgType MessageType
 	InType  reflect.Type
 	Func    reflect.Value
+	WithCtx bool //第一个参数为context.Context
+	WithErr bool //最后一个返回值为error
 }
 type Handler struct {
-	handlers map[int32]HandlerItem
+	handlers     map[int32]HandlerItem
+	interceptors []Interceptor
+	metrics      *RpcMetrics
+	idempotency  *idempotency
 }
 
 func NewHandler() *Handler {
@@ -39,14 +51,34 @@ func NewHandler() *Handler {
 	}
 }
 
+// isSuitHandler 支持 func([context.Context,] *Req) [*Resp][, error]
 func (h *Handler) isSuitHandler(tf reflect.Type) bool {
-	if tf.NumIn() != 1 {
+	if tf.Kind() != reflect.Func {
 		return false
 	}
-	if tf.In(0).Kind() != reflect.Ptr {
+	switch tf.NumIn() {
+	case 1:
+	case 2:
+		if tf.In(0) != typeOfContext {
+			return false
+		}
+	default:
 		return false
 	}
-	if tf.NumOut() > 1 || (tf.NumOut() == 1 && tf.Out(0).Kind() != reflect.Ptr) {
+	if tf.In(tf.NumIn()-1).Kind() != reflect.Ptr {
+		return false
+	}
+	switch tf.NumOut() {
+	case 0:
+	case 1:
+		if tf.Out(0).Kind() != reflect.Ptr && tf.Out(0) != typeOfError {
+			return false
+		}
+	case 2:
+		if tf.Out(0).Kind() != reflect.Ptr || tf.Out(1) != typeOfError {
+			return false
+		}
+	default:
 		return false
 	}
 	return true
@@ -63,34 +95,142 @@ func (h *Handler) Register(msgId int32, v any) {
 		return
 	}
 	msgType := MessageType(MsgTypePublish)
-	if tf.NumOut() == 1 {
+	if tf.NumOut() > 0 && tf.Out(0).Kind() == reflect.Ptr {
 		msgType = MsgTypeRequest
 	}
 	h.handlers[msgId] = HandlerItem{
 		MsgType: msgType,
-		InType:  tf.In(0),
+		InType:  tf.In(tf.NumIn() - 1),
 		Func:    vf,
+		WithCtx: tf.NumIn() == 2,
+		WithErr: tf.NumOut() > 0 && tf.Out(tf.NumOut()-1) == typeOfError,
 	}
 }
 
+// isSuitStreamHandler 支持 func([context.Context,] *Req, *StreamSender) error
+func (h *Handler) isSuitStreamHandler(tf reflect.Type) bool {
+	if tf.Kind() != reflect.Func {
+		return false
+	}
+	switch tf.NumIn() {
+	case 2:
+	case 3:
+		if tf.In(0) != typeOfContext {
+			return false
+		}
+	default:
+		return false
+	}
+	if tf.In(tf.NumIn()-2).Kind() != reflect.Ptr || tf.In(tf.NumIn()-1) != typeOfStream {
+		return false
+	}
+	return tf.NumOut() == 1 && tf.Out(0) == typeOfError
+}
+
+// RegisterStream 注册流式处理器,通过StreamSender发送多个响应分片,返回后发送流结束标记
+func (h *Handler) RegisterStream(msgId int32, v any) {
+	if _, ok := h.handlers[msgId]; ok {
+		logger.Errorf("msgId has already been registered:%v", msgId)
+		return
+	}
+	vf, tf := reflect.ValueOf(v), reflect.TypeOf(v)
+	if !h.isSuitStreamHandler(tf) {
+		logger.Errorf("not suit stream handler:%+v", v)
+		return
+	}
+	h.handlers[msgId] = HandlerItem{
+		MsgType: MsgTypeStream,
+		InType:  tf.In(tf.NumIn() - 2),
+		Func:    vf,
+		WithCtx: tf.NumIn() == 3,
+		WithErr: true,
+	}
+}
+
+// Use 添加拦截器,作用于所有已注册的处理器
+func (h *Handler) Use(interceptors ...Interceptor) {
+	h.interceptors = append(h.interceptors, interceptors...)
+}
+
+// UseMetrics 统计各msgId的处理次数、错误、超时及耗时
+func (h *Handler) UseMetrics(metrics *RpcMetrics) {
+	h.metrics = metrics
+}
+
+// UseIdempotency 带幂等键的请求在ttl内只处理一次,重复请求返回缓存的响应
+func (h *Handler) UseIdempotency(store IdempotencyStore, ttl time.Duration) {
+	h.idempotency = newIdempotency(store, ttl)
+}
+
+func (item HandlerItem) call(ctx context.Context, in any) (any, error) {
+	args := []reflect.Value{reflect.ValueOf(in)}
+	if item.MsgType == MsgTypeStream {
+		args = append(args, reflect.ValueOf(streamSenderFromContext(ctx)))
+	}
+	if item.WithCtx {
+		args = append([]reflect.Value{reflect.ValueOf(ctx)}, args...)
+	}
+	resp := item.Func.Call(args)
+	if item.WithErr {
+		if errItem := resp[len(resp)-1]; !errItem.IsNil() {
+			return nil, errItem.Interface().(error)
+		}
+	}
+	if item.MsgType == MsgTypeRequest {
+		return resp[0].Interface(), nil
+	}
+	return nil, nil
+}
+
 func (h *Handler) DealMsg(codeType string, server ServerRpc, req *MsgRpc) ([]byte, error) {
+	if h.metrics == nil {
+		return h.dealMsg(codeType, server, req)
+	}
+	begin := time.Now()
+	resp, err := h.dealMsg(codeType, server, req)
+	h.metrics.ObserveServer(req.MsgId, codeType, time.Since(begin), err)
+	return resp, err
+}
+
+func (h *Handler) dealMsg(codeType string, server ServerRpc, req *MsgRpc) ([]byte, error) {
 	msgId, msgData := req.MsgId, req.MsgData.([]byte)
 	if handler, ok := h.handlers[msgId]; ok {
 		if handler.MsgType != req.MsgType {
-			return nil, fmt.Errorf("req msg type not suit handler msg type, msgId:%v, handler:%+v, req:%+v", msgId, handler, req)
+			return nil, RemoteErrorf(ErrCodeBadRequest, "req msg type not suit handler msg type, msgId:%v, msgType:%v", msgId, req.MsgType)
+		}
+		//调用方已放弃等待,不再处理
+		ctx := WithMetadata(req.Context(), req.Meta)
+		if err := ctx.Err(); err != nil {
+			return nil, RemoteErrorf(ErrCodeExpired, "req msg expired, msgId:%v, err:%v", msgId, err)
+		}
+		var sender *StreamSender
+		if handler.MsgType == MsgTypeStream {
+			if req.reply == nil {
+				return nil, RemoteErrorf(ErrCodeBadRequest, "stream req has no reply, msgId:%v", msgId)
+			}
+			sender = &StreamSender{msgId: msgId, coder: server.GetCoder(codeType), send: req.reply}
+			ctx = withStreamSender(ctx, sender)
 		}
 		inElem := reflect.New(handler.InType.Elem()).Interface()
 		err := server.DecodeMsg(codeType, msgData, inElem)
+		if err != nil {
+			return nil, RemoteErrorf(ErrCodeBadRequest, "req msg decode failed, msgId:%v, err:%v", msgId, err)
+		}
+		final := Invoker(handler.call)
+		if key := req.Meta.IdempotencyKey(); h.idempotency != nil && handler.MsgType == MsgTypeRequest && len(key) > 0 {
+			final = h.idempotency.invoker(codeType, server, msgId, key, msgData, handler.Func.Type().Out(0), final)
+		}
+		outItem, err := chainInterceptors(h.interceptors, msgId, final)(ctx, inElem)
 		if err != nil {
 			return nil, err
 		}
-		args := []reflect.Value{reflect.ValueOf(inElem)}
-		resp := handler.Func.Call(args)
-		if handler.MsgType == MsgTypeRequest && len(resp) > 0 {
-			outItem := resp[0].Interface()
+		switch handler.MsgType {
+		case MsgTypeRequest:
 			return server.Response(codeType, outItem), nil
+		case MsgTypeStream:
+			return sender.end()
 		}
 		return nil, nil
 	}
-	return nil, fmt.Errorf("req msg not suit handler, msgId:%v, handlers:%+v, req:%+v", msgId, h.handlers, req)
+	return nil, RemoteErrorf(ErrCodeNotFound, "req msg not suit handler, msgId:%v", msgId)
 }
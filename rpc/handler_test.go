@@ -0,0 +1,105 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+// handlerTestRpc 仅实现处理器需要的编解码方法
+type handlerTestRpc struct {
+	ServerRpc
+	coder   EncoderRpc
+	handler MsgHandler
+}
+
+func newHandlerTestRpc() *handlerTestRpc {
+	return &handlerTestRpc{coder: NewRpcEncoder(serialize.NewJsonSerializer())}
+}
+
+func (r *handlerTestRpc) DecodeMsg(codeType string, data []byte, v any) error {
+	return r.coder.DecodeMsg(data, v)
+}
+
+func (r *handlerTestRpc) GetCoder(codeType string) EncoderRpc {
+	return r.coder
+}
+
+func (r *handlerTestRpc) Response(codeType string, v any) []byte {
+	return r.coder.Response(v)
+}
+
+func (r *handlerTestRpc) ResponseError(codeType string, err error) []byte {
+	return responseError(r.coder, err)
+}
+
+func (r *handlerTestRpc) request(h MsgHandler, msgId int32, req, resp any) error {
+	return r.requestMeta(h, msgId, nil, req, resp)
+}
+
+func (r *handlerTestRpc) requestMeta(h MsgHandler, msgId int32, meta Metadata, req, resp any) error {
+	data, err := r.coder.EncodeMsg(req)
+	if err != nil {
+		return err
+	}
+	out, err := h.DealMsg(CodeTypeJson, r, &MsgRpc{MsgType: MsgTypeRequest, MsgId: msgId, MsgData: data, Meta: meta})
+	if err != nil {
+		out = r.ResponseError(CodeTypeJson, err)
+	}
+	return r.coder.Decode(out, &MsgRpc{MsgData: resp})
+}
+
+func (r *handlerTestRpc) RequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.request(r.handler, s.msgId, s.req, s.resp)
+}
+
+func (r *handlerTestRpc) QueueRequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.request(r.handler, s.msgId, s.req, s.resp)
+}
+
+func TestHandlerError(t *testing.T) {
+	h, r := NewHandler(), newHandlerTestRpc()
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		return &treaty.LoginResponse{Msg: "ok"}, nil
+	})
+	h.Register(2, func(ctx context.Context, req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		return nil, errors.New("internal")
+	})
+	resp := &treaty.LoginResponse{}
+	if err := r.request(h, 1, &treaty.LoginRequest{Uid: 1}, resp); err != nil || resp.Msg != "ok" {
+		t.Fatalf("request failed, err:%v, resp:%+v", err, resp)
+	}
+	cases := []struct {
+		msgId int32
+		uid   int32
+		code  int32
+	}{
+		{1, 0, 1001},
+		{2, 1, ErrCodeInternal},
+		{3, 1, ErrCodeNotFound},
+	}
+	for _, c := range cases {
+		err := r.request(h, c.msgId, &treaty.LoginRequest{Uid: c.uid}, &treaty.LoginResponse{})
+		if re, ok := AsRemoteError(err); !ok || re.Code != c.code {
+			t.Fatalf("msgId:%v want code:%v, got:%v", c.msgId, c.code, err)
+		}
+	}
+}
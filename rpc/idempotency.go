@@ -0,0 +1,181 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultIdempotencyTTL   = 24 * time.Hour
+	DefaultIdempotencyClaim = 10 * time.Second //处理中记录的租约,处理期间定时续期,进程崩溃后到期释放
+
+	idemPending = 0x00 //处理中,摘要后为占用者令牌
+	idemDone    = 0x01 //已处理,后续为响应消息体
+	idemHead    = 1 + sha256.Size
+	idemRetry   = 3 //占用失败后记录恰好被释放时重新占用的次数
+)
+
+var errIdemMissing = errors.New("idempotency record missing")
+
+// IdempotencyStore 幂等记录存储,stores.StoreKeeper满足该接口
+type IdempotencyStore interface {
+	SetRawNx(key string, value []byte, expire time.Duration) (bool, error)
+	GetRaw(key string) ([]byte, error)
+	CompareAndSwapRaw(key string, old, value []byte, expire time.Duration) (bool, error)
+	CompareAndDelRaw(key string, old []byte) (bool, error)
+}
+
+// idempotency 带幂等键的请求只处理一次,重复请求返回缓存的响应
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+	claim time.Duration
+}
+
+func newIdempotency(store IdempotencyStore, ttl time.Duration) *idempotency {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	return &idempotency{store: store, ttl: ttl, claim: DefaultIdempotencyClaim}
+}
+
+// idemKey 响应按编码类型缓存,不同msgId的相同幂等键互不影响
+func idemKey(msgId int32, codeType, key string) string {
+	return fmt.Sprintf("rpc_idem:%v:%v:%v", msgId, codeType, key)
+}
+
+// invoker 作为拦截器链的最内层,重复请求同样经过鉴权、限流及统计等拦截器,
+// 记录中保存请求体摘要,相同幂等键携带不同请求体时返回冲突;
+// 处理中记录带占用者令牌,续期、保存及释放均比较令牌,租约过期后不会覆盖或删除其他占用者的记录
+func (i *idempotency) invoker(codeType string, server ServerRpc, msgId int32, key string, payload []byte, outType reflect.Type, next Invoker) Invoker {
+	return func(ctx context.Context, in any) (out any, err error) {
+		storeKey := idemKey(msgId, codeType, key)
+		digest := sha256.Sum256(payload)
+		token := uuid.New()
+		claim := make([]byte, 0, idemHead+len(token))
+		claim = append(append(append(claim, idemPending), digest[:]...), token[:]...)
+		for retry := 0; ; retry++ {
+			claimed, err := i.store.SetRawNx(storeKey, claim, i.claim)
+			if err != nil {
+				return nil, RemoteErrorf(ErrCodeInternal, "idempotency claim failed, msgId:%v, key:%v, err:%v", msgId, key, err)
+			}
+			if claimed {
+				break
+			}
+			out, err := i.load(codeType, server, msgId, key, digest[:], outType)
+			if err != errIdemMissing {
+				return out, err
+			}
+			//记录在占用与读取之间被释放或过期,重新占用
+			if retry >= idemRetry {
+				return nil, RemoteErrorf(ErrCodeConflict, "request in progress, msgId:%v, key:%v", msgId, key)
+			}
+		}
+		stop := i.renew(storeKey, claim, msgId, key)
+		//处理失败、编码失败及panic时释放幂等键以便重试
+		saved := false
+		defer func() {
+			stop()
+			if saved {
+				return
+			}
+			if _, err := i.store.CompareAndDelRaw(storeKey, claim); err != nil {
+				logger.Errorf("idempotency release failed, msgId:%v, key:%v, err:%v", msgId, key, err)
+			}
+		}()
+		out, err = next(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		body, err := server.GetCoder(codeType).EncodeMsg(out)
+		if err != nil {
+			return nil, err
+		}
+		record := make([]byte, 0, idemHead+len(body))
+		record = append(append(append(record, idemDone), digest[:]...), body...)
+		//处理已完成,缓存失败时仅记录,处理中记录到期前重复请求将得到冲突错误
+		if ok, err := i.store.CompareAndSwapRaw(storeKey, claim, record, i.ttl); err != nil {
+			logger.Errorf("idempotency save failed, msgId:%v, key:%v, err:%v", msgId, key, err)
+		} else if !ok {
+			logger.Errorf("idempotency claim lost before save, msgId:%v, key:%v", msgId, key)
+		}
+		saved = true
+		return out, nil
+	}
+}
+
+// renew 处理期间每隔租约的三分之一续期一次,租约不依赖调用方的截止时间
+func (i *idempotency) renew(storeKey string, claim []byte, msgId int32, key string) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(i.claim / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ok, err := i.store.CompareAndSwapRaw(storeKey, claim, claim, i.claim)
+				if err != nil {
+					logger.Errorf("idempotency renew failed, msgId:%v, key:%v, err:%v", msgId, key, err)
+				} else if !ok {
+					logger.Errorf("idempotency claim lost, msgId:%v, key:%v", msgId, key)
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// load 读取已有记录,处理完成时返回缓存的响应,记录不存在时返回errIdemMissing
+func (i *idempotency) load(codeType string, server ServerRpc, msgId int32, key string, digest []byte, outType reflect.Type) (any, error) {
+	record, err := i.store.GetRaw(idemKey(msgId, codeType, key))
+	if errors.Is(err, redis.Nil) {
+		return nil, errIdemMissing
+	}
+	if err != nil {
+		return nil, RemoteErrorf(ErrCodeInternal, "idempotency load failed, msgId:%v, key:%v, err:%v", msgId, key, err)
+	}
+	if len(record) < idemHead || !bytes.Equal(record[1:idemHead], digest) {
+		return nil, RemoteErrorf(ErrCodeConflict, "idempotency key reused with different request, msgId:%v, key:%v", msgId, key)
+	}
+	if record[0] != idemDone {
+		return nil, RemoteErrorf(ErrCodeConflict, "request in progress, msgId:%v, key:%v", msgId, key)
+	}
+	//还原为处理器的响应类型,外层拦截器看到的响应与首次处理一致
+	var out reflect.Value
+	if outType.Kind() == reflect.Pointer {
+		out = reflect.New(outType.Elem())
+	} else {
+		out = reflect.New(outType)
+	}
+	if err = server.DecodeMsg(codeType, record[idemHead:], out.Interface()); err != nil {
+		return nil, RemoteErrorf(ErrCodeInternal, "idempotency decode failed, msgId:%v, key:%v, err:%v", msgId, key, err)
+	}
+	if outType.Kind() == reflect.Pointer {
+		return out.Interface(), nil
+	}
+	return out.Elem().Interface(), nil
+}
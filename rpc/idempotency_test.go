@@ -0,0 +1,260 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fengyuqin/kungfu/v2/stores"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+	"github.com/go-redis/redis/v8"
+)
+
+// memIdemStore 内存幂等存储,不处理过期,记录最近一次占用的过期时间
+type memIdemStore struct {
+	data  map[string][]byte
+	claim time.Duration
+	lock  sync.Mutex
+}
+
+func (s *memIdemStore) SetRawNx(key string, value []byte, expire time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.data[key]; ok {
+		return false, nil
+	}
+	s.data[key] = value
+	s.claim = expire
+	return true, nil
+}
+
+func (s *memIdemStore) GetRaw(key string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.data[key]; ok {
+		return v, nil
+	}
+	return nil, redis.Nil
+}
+
+func (s *memIdemStore) CompareAndSwapRaw(key string, old, value []byte, expire time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.data[key]; !ok || !bytes.Equal(v, old) {
+		return false, nil
+	}
+	s.data[key] = value
+	return true, nil
+}
+
+func (s *memIdemStore) CompareAndDelRaw(key string, old []byte) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.data[key]; !ok || !bytes.Equal(v, old) {
+		return false, nil
+	}
+	delete(s.data, key)
+	return true, nil
+}
+
+// missIdemStore 前几次占用失败且记录不存在,模拟记录在占用与读取之间被释放
+type missIdemStore struct {
+	*memIdemStore
+	misses int
+}
+
+func (s *missIdemStore) SetRawNx(key string, value []byte, expire time.Duration) (bool, error) {
+	if s.misses > 0 {
+		s.misses--
+		return false, nil
+	}
+	return s.memIdemStore.SetRawNx(key, value, expire)
+}
+
+func TestHandlerIdempotency(t *testing.T) {
+	store := &memIdemStore{data: make(map[string][]byte)}
+	h, r := NewHandler(), newHandlerTestRpc()
+	h.UseIdempotency(store, time.Minute)
+	runs := 0
+	block := make(chan struct{})
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		runs++
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		if req.Uid == 2 {
+			<-block
+		}
+		return &treaty.LoginResponse{Msg: fmt.Sprint("grant-", runs)}, nil
+	})
+	meta := Metadata{MetaIdemKey: "k1"}
+	for i := 0; i < 3; i++ {
+		resp := &treaty.LoginResponse{}
+		if err := r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 1}, resp); err != nil || resp.Msg != "grant-1" {
+			t.Fatalf("want cached response, err:%v, resp:%+v", err, resp)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("handler should run once, runs:%v", runs)
+	}
+	//失败不缓存,重试重新处理
+	meta = Metadata{MetaIdemKey: "k2"}
+	for i := 0; i < 2; i++ {
+		if err := r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 0}, &treaty.LoginResponse{}); err == nil {
+			t.Fatal("want error")
+		}
+	}
+	if runs != 3 {
+		t.Fatalf("failed request should be retried, runs:%v", runs)
+	}
+	//处理中的重复请求返回冲突
+	meta = Metadata{MetaIdemKey: "k3"}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 2}, &treaty.LoginResponse{})
+	}()
+	for {
+		if _, err := store.GetRaw(idemKey(1, CodeTypeJson, "k3")); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	err := r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 2}, &treaty.LoginResponse{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeConflict {
+		t.Fatalf("want conflict, got:%v", err)
+	}
+	close(block)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerIdempotencyGuard(t *testing.T) {
+	store := &memIdemStore{data: make(map[string][]byte)}
+	h, r := NewHandler(), newHandlerTestRpc()
+	h.UseIdempotency(store, time.Hour)
+	//重复请求同样经过拦截器
+	intercepted := 0
+	h.Use(RecoveryInterceptor(), func(ctx context.Context, msgId int32, in any, next Invoker) (any, error) {
+		intercepted++
+		return next(ctx, in)
+	})
+	runs := 0
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		runs++
+		if runs == 1 {
+			panic("boom")
+		}
+		return &treaty.LoginResponse{Msg: fmt.Sprint("grant-", req.Uid)}, nil
+	})
+	meta := Metadata{MetaIdemKey: "k1"}
+	//panic释放幂等键,重试可重新处理
+	if err := r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 1}, &treaty.LoginResponse{}); err == nil {
+		t.Fatal("want panic error")
+	}
+	if store.claim != DefaultIdempotencyClaim {
+		t.Fatalf("pending claim should not depend on the deadline, got:%v", store.claim)
+	}
+	for i := 0; i < 2; i++ {
+		resp := &treaty.LoginResponse{}
+		if err := r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 1}, resp); err != nil || resp.Msg != "grant-1" {
+			t.Fatalf("want cached response, err:%v, resp:%+v", err, resp)
+		}
+	}
+	if runs != 2 || intercepted != 3 {
+		t.Fatalf("runs:%v, intercepted:%v", runs, intercepted)
+	}
+	//相同幂等键携带不同请求体
+	err := r.requestMeta(h, 1, meta, &treaty.LoginRequest{Uid: 2}, &treaty.LoginResponse{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeConflict {
+		t.Fatalf("want conflict, got:%v", err)
+	}
+}
+
+func TestHandlerIdempotencyMissing(t *testing.T) {
+	store := &missIdemStore{memIdemStore: &memIdemStore{data: make(map[string][]byte)}, misses: 2}
+	h, r := NewHandler(), newHandlerTestRpc()
+	h.UseIdempotency(store, time.Minute)
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		return &treaty.LoginResponse{Msg: fmt.Sprint("grant-", req.Uid)}, nil
+	})
+	//记录已释放时重新占用并处理
+	resp := &treaty.LoginResponse{}
+	if err := r.requestMeta(h, 1, Metadata{MetaIdemKey: "k1"}, &treaty.LoginRequest{Uid: 1}, resp); err != nil || resp.Msg != "grant-1" {
+		t.Fatalf("want response, err:%v, resp:%+v", err, resp)
+	}
+	//多次重新占用仍失败时返回可重试的冲突
+	store.misses = idemRetry + 1
+	err := r.requestMeta(h, 1, Metadata{MetaIdemKey: "k2"}, &treaty.LoginRequest{Uid: 1}, &treaty.LoginResponse{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeConflict {
+		t.Fatalf("want conflict, got:%v", err)
+	}
+}
+
+func TestHandlerIdempotencyLease(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := stores.NewStoreRedis(stores.WithRedisEndpoints([]string{mr.Addr()}), stores.WithRedisDialTimeout(time.Second))
+	h, r := NewHandler(), newHandlerTestRpc()
+	h.UseIdempotency(store, time.Minute)
+	h.idempotency.claim = 60 * time.Millisecond
+	started, block := make(chan struct{}), make(chan struct{})
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		started <- struct{}{}
+		<-block
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		return &treaty.LoginResponse{Msg: fmt.Sprint("grant-", req.Uid)}, nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- r.requestMeta(h, 1, Metadata{MetaIdemKey: "k1"}, &treaty.LoginRequest{Uid: 1}, &treaty.LoginResponse{})
+	}()
+	<-started
+	//处理时间超过租约时续期,重复请求得到冲突而不会再次处理
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+		mr.FastForward(40 * time.Millisecond)
+	}
+	err := r.requestMeta(h, 1, Metadata{MetaIdemKey: "k1"}, &treaty.LoginRequest{Uid: 1}, &treaty.LoginResponse{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeConflict {
+		t.Fatalf("want conflict, got:%v", err)
+	}
+	close(block)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	//租约过期后被其他请求占用,原占用者失败时不删除对方的记录
+	block = make(chan struct{})
+	go func() {
+		done <- r.requestMeta(h, 1, Metadata{MetaIdemKey: "k2"}, &treaty.LoginRequest{Uid: 0}, &treaty.LoginResponse{})
+	}()
+	<-started
+	key := store.GetKey(idemKey(1, CodeTypeJson, "k2"))
+	if err = mr.Set(key, "other"); err != nil {
+		t.Fatal(err)
+	}
+	close(block)
+	if err = <-done; err == nil {
+		t.Fatal("want error")
+	}
+	if v, err := mr.Get(key); err != nil || v != "other" {
+		t.Fatalf("other claimant's record should be kept, v:%v, err:%v", v, err)
+	}
+}
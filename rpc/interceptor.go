@@ -0,0 +1,188 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/utils"
+)
+
+// Invoker 调用下一个拦截器或最终的处理器
+type Invoker func(ctx context.Context, in any) (any, error)
+
+// Interceptor 服务端拦截器,in为解码后的请求,返回响应或错误
+type Interceptor func(ctx context.Context, msgId int32, in any, next Invoker) (any, error)
+
+// chainInterceptors 按注册顺序组装拦截器,先注册的在最外层
+func chainInterceptors(interceptors []Interceptor, msgId int32, final Invoker) Invoker {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := interceptors[i], next
+		next = func(ctx context.Context, in any) (any, error) {
+			return interceptor(ctx, msgId, in, inner)
+		}
+	}
+	return next
+}
+
+// RecoveryInterceptor 处理器panic时恢复并返回内部错误
+func RecoveryInterceptor() Interceptor {
+	return func(ctx context.Context, msgId int32, in any, next Invoker) (out any, err error) {
+		defer func() {
+			if utils.GetQuickCrash() {
+				return
+			}
+			if x := recover(); x != nil {
+				logger.Reportf("rpc handler panic recovered, msgId:%v, err:%v\n%s", msgId, x, debug.Stack())
+				out, err = nil, RemoteErrorf(ErrCodeInternal, "rpc handler panic, msgId:%v", msgId)
+			}
+		}()
+		return next(ctx, in)
+	}
+}
+
+// LoggerInterceptor 记录处理耗时及错误
+func LoggerInterceptor() Interceptor {
+	return func(ctx context.Context, msgId int32, in any, next Invoker) (any, error) {
+		begin := time.Now()
+		out, err := next(ctx, in)
+		if err != nil {
+			logger.Errorf("rpc handler msgId:%v, cost:%v, err:%v", msgId, time.Since(begin), err)
+		} else {
+			logger.Infof("rpc handler msgId:%v, cost:%v", msgId, time.Since(begin))
+		}
+		return out, err
+	}
+}
+
+// 出站调用方法
+const (
+	MethodSendMsg            = "SendMsg"
+	MethodPublish            = "Publish"
+	MethodQueuePublish       = "QueuePublish"
+	MethodPublishBroadcast   = "PublishBroadcast"
+	MethodRequest            = "Request"
+	MethodQueueRequest       = "QueueRequest"
+	MethodRequestStream      = "RequestStream"
+	MethodQueueRequestStream = "QueueRequestStream"
+)
+
+// ClientInvoker 调用下一个客户端拦截器或最终的发送方法
+type ClientInvoker func(ctx context.Context, s ReqBuilder) error
+
+// ClientInterceptor 客户端拦截器,method为出站调用方法
+type ClientInterceptor func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error
+
+// ClientChain 客户端拦截器链,由各rpc实现内嵌,添加时复制,调用中可安全添加
+type ClientChain struct {
+	clientInterceptors atomic.Pointer[[]ClientInterceptor]
+	clientLock         sync.Mutex
+}
+
+type rawInvokeKey struct{}
+
+// withRawInvoke 跳过客户端拦截器直接发送,用于已经过拦截器的调用再次发送,如延迟消息到期投递
+func withRawInvoke(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawInvokeKey{}, true)
+}
+
+// UseClientInterceptors 添加客户端拦截器,先添加的在最外层
+func (c *ClientChain) UseClientInterceptors(interceptors ...ClientInterceptor) {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+	var res []ClientInterceptor
+	if old := c.clientInterceptors.Load(); old != nil {
+		res = append(res, *old...)
+	}
+	res = append(res, interceptors...)
+	c.clientInterceptors.Store(&res)
+}
+
+func (c *ClientChain) loadClientInterceptors() []ClientInterceptor {
+	if res := c.clientInterceptors.Load(); res != nil {
+		return *res
+	}
+	return nil
+}
+
+func (c *ClientChain) intercept(ctx context.Context, method string, s ReqBuilder, final ClientInvoker) error {
+	//延迟消息应由Scheduler拦截器写入调度,未安装时拒绝发送而非立即投递
+	next := func(ctx context.Context, s ReqBuilder) error {
+		if !s.deliverAt.IsZero() {
+			return ErrScheduleDisabled
+		}
+		return final(ctx, s)
+	}
+	if raw, _ := ctx.Value(rawInvokeKey{}).(bool); raw {
+		return next(ctx, s)
+	}
+	interceptors := c.loadClientInterceptors()
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := interceptors[i], next
+		next = func(ctx context.Context, s ReqBuilder) error {
+			return interceptor(ctx, method, s, inner)
+		}
+	}
+	return next(ctx, s)
+}
+
+// RetryInterceptor 幂等请求失败后按指数退避重试,远程错误不重试
+func RetryInterceptor(attempts int, backoff time.Duration, msgIds ...int32) ClientInterceptor {
+	idempotent := make(map[int32]bool, len(msgIds))
+	for _, msgId := range msgIds {
+		idempotent[msgId] = true
+	}
+	return func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+		if (method != MethodRequest && method != MethodQueueRequest) || !idempotent[s.msgId] {
+			return next(ctx, s)
+		}
+		var err error
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+					return err
+				case <-time.After(backoff << (i - 1)):
+				}
+				logger.Warnf("rpc retry %v, msgId:%v, attempt:%v, last err:%v", method, s.msgId, i+1, err)
+			}
+			if err = next(ctx, s); err == nil {
+				return nil
+			}
+			if _, ok := AsRemoteError(err); ok {
+				return err
+			}
+		}
+		return err
+	}
+}
+
+// ClientLoggerInterceptor 记录出站调用耗时及错误
+func ClientLoggerInterceptor() ClientInterceptor {
+	return func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+		begin := time.Now()
+		err := next(ctx, s)
+		if err != nil {
+			logger.Errorf("rpc %v msgId:%v, serverType:%v, cost:%v, err:%v", method, s.msgId, s.serverType, time.Since(begin), err)
+		} else {
+			logger.Infof("rpc %v msgId:%v, serverType:%v, cost:%v", method, s.msgId, s.serverType, time.Since(begin))
+		}
+		return err
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	h, r := NewHandler(), newHandlerTestRpc()
+	var trace []string
+	mark := func(name string) Interceptor {
+		return func(ctx context.Context, msgId int32, in any, next Invoker) (any, error) {
+			trace = append(trace, name+">")
+			out, err := next(ctx, in)
+			trace = append(trace, "<"+name)
+			return out, err
+		}
+	}
+	auth := func(ctx context.Context, msgId int32, in any, next Invoker) (any, error) {
+		if in.(*treaty.LoginRequest).Token != "ok" {
+			return nil, NewRemoteError(401, "token invalid")
+		}
+		return next(ctx, in)
+	}
+	h.Use(RecoveryInterceptor(), mark("a"), mark("b"), auth)
+	h.Register(1, func(req *treaty.LoginRequest) *treaty.LoginResponse {
+		if req.Uid == 0 {
+			panic("uid empty")
+		}
+		trace = append(trace, "handler")
+		return &treaty.LoginResponse{Msg: "ok"}
+	})
+	resp := &treaty.LoginResponse{}
+	if err := r.request(h, 1, &treaty.LoginRequest{Uid: 1, Token: "ok"}, resp); err != nil || resp.Msg != "ok" {
+		t.Fatalf("request failed, err:%v, resp:%+v", err, resp)
+	}
+	want := []string{"a>", "b>", "handler", "<b", "<a"}
+	if len(trace) != len(want) {
+		t.Fatalf("trace not match, want:%v, got:%v", want, trace)
+	}
+	for i := range want {
+		if trace[i] != want[i] {
+			t.Fatalf("trace not match, want:%v, got:%v", want, trace)
+		}
+	}
+	err := r.request(h, 1, &treaty.LoginRequest{Uid: 1}, &treaty.LoginResponse{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != 401 {
+		t.Fatalf("want auth error, got:%v", err)
+	}
+	err = r.request(h, 1, &treaty.LoginRequest{Token: "ok"}, &treaty.LoginResponse{})
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeInternal {
+		t.Fatalf("want panic error, got:%v", err)
+	}
+}
+
+func TestClientInterceptorRetry(t *testing.T) {
+	chain := &ClientChain{}
+	var methods []string
+	chain.UseClientInterceptors(func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+		methods = append(methods, method)
+		return next(ctx, s)
+	}, RetryInterceptor(3, time.Millisecond, 1))
+	//模拟故障注入:前两次失败
+	calls := 0
+	fault := func(ctx context.Context, s ReqBuilder) error {
+		calls++
+		if calls < 3 {
+			return errors.New("fault injected")
+		}
+		return nil
+	}
+	req := DefaultReqBuilder().SetMsgId(1).Build()
+	if err := chain.intercept(context.Background(), MethodRequest, req, fault); err != nil || calls != 3 {
+		t.Fatalf("retry failed, err:%v, calls:%v", err, calls)
+	}
+	//非幂等msgId不重试
+	calls = 0
+	req = DefaultReqBuilder().SetMsgId(2).Build()
+	if err := chain.intercept(context.Background(), MethodRequest, req, fault); err == nil || calls != 1 {
+		t.Fatalf("non idempotent retried, err:%v, calls:%v", err, calls)
+	}
+	//远程错误不重试
+	calls = 0
+	req = DefaultReqBuilder().SetMsgId(1).Build()
+	remote := func(ctx context.Context, s ReqBuilder) error {
+		calls++
+		return NewRemoteError(ErrCodeInternal, "remote")
+	}
+	if err := chain.intercept(context.Background(), MethodRequest, req, remote); err == nil || calls != 1 {
+		t.Fatalf("remote error retried, err:%v, calls:%v", err, calls)
+	}
+	if len(methods) != 3 || methods[0] != MethodRequest {
+		t.Fatalf("methods not match:%v", methods)
+	}
+}
+
+func TestClientChainConcurrentUse(t *testing.T) {
+	chain := &ClientChain{}
+	var calls int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			chain.UseClientInterceptors(func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+				atomic.AddInt32(&calls, 1)
+				return next(ctx, s)
+			})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		_ = chain.intercept(context.Background(), MethodPublish, ReqBuilder{}, func(ctx context.Context, s ReqBuilder) error {
+			return nil
+		})
+	}
+	<-done
+	if n := len(chain.loadClientInterceptors()); n != 100 {
+		t.Fatalf("interceptors lost, got:%v", n)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/utils"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	durableOverloadDelay = time.Second
+	durableSuffix        = ".durable" //持久化队列主题后缀,与普通队列请求分开,避免被stream截获
+	deadLetterSuffix     = ".dlq"     //默认死信主题后缀
+)
+
+// 死信消息头
+const (
+	HeaderDlqSubject   = "Kungfu-Dlq-Subject"   //原始主题
+	HeaderDlqReason    = "Kungfu-Dlq-Reason"    //转入死信原因
+	HeaderDlqDelivered = "Kungfu-Dlq-Delivered" //已投递次数
+)
+
+// streamName 由主题生成stream名称,stream名称不能包含 . * > / 及空白
+func streamName(sub string) string {
+	return "KF_" + strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' {
+			return c
+		}
+		return '_'
+	}, sub)
+}
+
+// durableSubject 持久化队列使用独立主题,普通队列请求及发布不会写入stream
+func durableSubject(sub string) string {
+	return sub + durableSuffix
+}
+
+// ensureStream 确保stream存在,不存在时创建
+func (r *NatsRpc) ensureStream(name, sub string, retention nats.RetentionPolicy) error {
+	if _, ok := r.streams.Load(name); ok {
+		return nil
+	}
+	_, err := r.JetStream.StreamInfo(name)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = r.JetStream.AddStream(&nats.StreamConfig{
+			Name:      name,
+			Subjects:  []string{sub},
+			Retention: retention,
+			Storage:   nats.FileStorage,
+		})
+		if errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("ensure stream failed, stream:%v, sub:%v, err:%w", name, sub, err)
+	}
+	r.streams.Store(name, true)
+	return nil
+}
+
+func (s RssBuilder) deadLetterSubject(sub string) string {
+	if len(s.deadLetter) > 0 {
+		return s.deadLetter
+	}
+	return sub + deadLetterSuffix
+}
+
+// durableSubscribe 持久化队列订阅,同一队列共享一个durable消费者,显式确认
+func (r *NatsRpc) durableSubscribe(s RssBuilder, sub string, coder EncoderRpc) error {
+	if s.maxDeliver < 1 {
+		s.maxDeliver = DefaultMaxDeliver
+	}
+	sub = durableSubject(sub)
+	stream := streamName(sub)
+	if err := r.ensureStream(stream, sub, nats.WorkQueuePolicy); err != nil {
+		return err
+	}
+	dlq := s.deadLetterSubject(sub)
+	if err := r.ensureStream(streamName(dlq), dlq, nats.LimitsPolicy); err != nil {
+		return err
+	}
+	//多投递一次,处理中崩溃的消息在最后一次投递时转入死信
+	d, err := r.dispatchers.add(s)
+	if err != nil {
+		return err
+	}
+	_, err = r.JetStream.QueueSubscribe(sub, s.queue, func(msg *nats.Msg) {
+		d.dispatch(msg.Data, func() {
+			r.DealDurableMsg(msg, s, dlq, coder)
+		}, func() {
+			//过载时延迟重新投递,计入投递次数
+			logger.Warnf("durable subscription overloaded, redeliver later, sub:%v", sub)
+			if err := msg.NakWithDelay(durableOverloadDelay); err != nil {
+				logger.Error(err)
+			}
+		})
+	}, nats.BindStream(stream), nats.Durable(s.queue), nats.ManualAck(), nats.AckExplicit(),
+		nats.MaxDeliver(s.maxDeliver+1), nats.AckWait(s.ackWait))
+	return err
+}
+
+// DealDurableMsg 处理成功确认,可重试错误重新投递,不可重试错误或超过投递次数转入死信
+func (r *NatsRpc) DealDurableMsg(msg *nats.Msg, s RssBuilder, dlq string, coder EncoderRpc) {
+	meta, err := msg.Metadata()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	delivered := int(meta.NumDelivered)
+	if delivered > s.maxDeliver {
+		r.deadLetter(msg, dlq, delivered, fmt.Errorf("max deliver exceeded:%v", s.maxDeliver))
+		return
+	}
+	//重新投递的消息签名不视为重放
+	req := &MsgRpc{durable: true}
+	if err = decodeStoredMsg(coder, msg.Data, req); err != nil {
+		r.deadLetter(msg, dlq, delivered, err)
+		return
+	}
+	err = durableCall(s.callback, req, coder)
+	if r.DebugMsg {
+		logger.Infof("DealDurableMsg,msgType: %v, msgId: %v, delivered: %v, err: %v", req.MsgType, req.MsgId, delivered, err)
+	}
+	if err == nil {
+		if err = msg.Ack(); err != nil {
+			logger.Error(err)
+		}
+		return
+	}
+	if re, ok := AsRemoteError(err); (ok && (re.Code == ErrCodeBadRequest || re.Code == ErrCodeNotFound)) || delivered >= s.maxDeliver {
+		r.deadLetter(msg, dlq, delivered, err)
+		return
+	}
+	logger.Warnf("durable msg failed, redeliver later, msgId:%v, delivered:%v, err:%v", req.MsgId, delivered, err)
+	if err = msg.Nak(); err != nil {
+		logger.Error(err)
+	}
+}
+
+// durableCall 调用处理函数,panic及错误帧视为处理失败,用于持久化消息及死信重新处理
+func durableCall(callback CallbackFunc, req *MsgRpc, coder EncoderRpc) (err error) {
+	defer func() {
+		if utils.GetQuickCrash() {
+			return
+		}
+		if x := recover(); x != nil {
+			err = fmt.Errorf("rpc msg panic, msgId:%v, err:%v", req.MsgId, x)
+		}
+	}()
+	resp := callback(req)
+	if len(resp) == 0 {
+		return nil
+	}
+	return coder.Decode(resp, &MsgRpc{})
+}
+
+// deadLetter 原始消息转入死信主题后终止投递
+func (r *NatsRpc) deadLetter(msg *nats.Msg, dlq string, delivered int, reason error) {
+	logger.Errorf("durable msg dead letter, sub:%v, dlq:%v, delivered:%v, reason:%v", msg.Subject, dlq, delivered, reason)
+	dead := nats.NewMsg(dlq)
+	dead.Data = msg.Data
+	dead.Header.Set(HeaderDlqSubject, msg.Subject)
+	dead.Header.Set(HeaderDlqReason, reason.Error())
+	dead.Header.Set(HeaderDlqDelivered, strconv.Itoa(delivered))
+	if _, err := r.JetStream.PublishMsg(dead); err != nil {
+		//死信写入失败,等待重新投递
+		logger.Error(err)
+		return
+	}
+	if err := msg.Term(); err != nil {
+		logger.Error(err)
+	}
+}
+
+// durablePublish 写入JetStream并等待存储确认,请求ID作为去重ID
+func (r *NatsRpc) durablePublish(ctx context.Context, s ReqBuilder, sub string) error {
+	sub = durableSubject(sub)
+	if err := r.ensureStream(streamName(sub), sub, nats.WorkQueuePolicy); err != nil {
+		return err
+	}
+	coder := r.RpcCoder[s.codeType]
+	if coder == nil {
+		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	}
+	data, err := coder.Encode(s.message(MsgTypePublish))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withDialTimeout(ctx, r.dialTimeout(s))
+	defer cancel()
+	//持久化消息可能延后处理,不传递截止时间
+	msg := nats.NewMsg(sub)
+	msg.Data = data
+	var opts []nats.PubOpt
+	if _, ok := ctx.Deadline(); ok {
+		opts = append(opts, nats.Context(ctx))
+	}
+	if reqId := s.meta.RequestId(); len(reqId) > 0 {
+		opts = append(opts, nats.MsgId(reqId))
+	}
+	_, err = r.JetStream.PublishMsg(msg, opts...)
+	return err
+}
@@ -0,0 +1,155 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// runJetStreamServer 启动开启JetStream的内嵌nats服务
+func runJetStreamServer(t *testing.T) *server.Server {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+func TestStreamName(t *testing.T) {
+	if name := streamName("Rpc/backend/dq.json"); name != "KF_Rpc_backend_dq_json" {
+		t.Fatalf("stream name not match:%v", name)
+	}
+}
+
+func TestDurableCall(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer())
+	req := &MsgRpc{MsgType: MsgTypePublish, MsgId: 1, durable: true}
+	if err := durableCall(func(req *MsgRpc) []byte { return nil }, req, coder); err != nil {
+		t.Fatal(err)
+	}
+	err := durableCall(func(req *MsgRpc) []byte {
+		return coder.ResponseError(NewRemoteError(ErrCodeInternal, "db down"))
+	}, req, coder)
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeInternal {
+		t.Fatalf("want remote error, got:%v", err)
+	}
+	err = durableCall(func(req *MsgRpc) []byte { panic("boom") }, req, coder)
+	if err == nil {
+		t.Fatal("want panic error")
+	}
+}
+
+func TestDurableQueue(t *testing.T) {
+	ns := runJetStreamServer(t)
+	srv := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	r := NewRpcNats(WithNatsEndpoints([]string{ns.ClientURL()}), WithNatsServer(srv), WithNatsDialTimeout(time.Second))
+	defer r.Client.Close()
+	var done, failed int32
+	h := NewHandler()
+	h.Register(1, func(req *treaty.LoginRequest) *treaty.LoginResponse {
+		atomic.AddInt32(&done, 1)
+		return &treaty.LoginResponse{Msg: "core"}
+	})
+	h.Register(3, func(req *treaty.LoginRequest) {
+		atomic.AddInt32(&done, 1)
+	})
+	h.Register(2, func(req *treaty.LoginRequest) error {
+		atomic.AddInt32(&failed, 1)
+		return NewRemoteError(ErrCodeInternal, "db down")
+	})
+	callback := func(req *MsgRpc) []byte {
+		resp, err := h.DealMsg(CodeTypeJson, r, req)
+		if err != nil {
+			return r.ResponseError(CodeTypeJson, err)
+		}
+		return resp
+	}
+	b := NewRssBuilder(srv).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetCallback(callback)
+	//同一队列同时存在普通订阅及持久化订阅
+	if err := r.QueueSubscribe(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	durable := b.SetDurable(true).SetMaxDeliver(2).SetAckWait(time.Second).Build()
+	if err := r.QueueSubscribe(durable); err != nil {
+		t.Fatal(err)
+	}
+	sub := durableSubject("Rpc/" + treaty.RegSeverQueue("backend", DefaultQueue) + "/" + JsonSuffix)
+	dlq, err := r.Client.SubscribeSync(durable.deadLetterSubject(sub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewRpcNats(WithNatsEndpoints([]string{ns.ClientURL()}), WithNatsDialTimeout(time.Second))
+	defer client.Client.Close()
+	ctx := context.Background()
+	//普通队列请求由处理器回复,不被stream截获
+	resp, err := CallQueueWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, "backend", 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+	if err != nil || resp.Msg != "core" {
+		t.Fatalf("core queue request failed, err:%v, resp:%+v", err, resp)
+	}
+	//持久化发布后被消费确认
+	req := DefaultReqBuilder().SetServerType("backend").SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetDurable(true)
+	if err = client.QueuePublish(req.SetMsgId(3).SetReq(&treaty.LoginRequest{Uid: 1}).Build()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for atomic.LoadInt32(&done) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&done); n != 2 {
+		t.Fatalf("durable msg not consumed, handled:%v", n)
+	}
+	//处理失败重新投递,超过次数转入死信
+	if err = client.QueuePublish(req.SetMsgId(2).SetReq(&treaty.LoginRequest{Uid: 2}).Build()); err != nil {
+		t.Fatal(err)
+	}
+	dead, err := dlq.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&failed); n != 2 {
+		t.Fatalf("want 2 deliveries before dead letter, got:%v", n)
+	}
+	if dead.Header.Get(HeaderDlqSubject) != sub || dead.Header.Get(HeaderDlqDelivered) != "2" {
+		t.Fatalf("dead letter header not match:%v", dead.Header)
+	}
+	//死信转出后终止投递,工作队列清空
+	var msgs uint64
+	for deadline = time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		info, err := r.JetStream.StreamInfo(streamName(sub))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgs = info.State.Msgs; msgs == 0 {
+			break
+		}
+	}
+	if msgs != 0 {
+		t.Fatalf("work queue not drained, msgs:%v", msgs)
+	}
+	if _, err = r.JetStream.StreamInfo(streamName("Rpc/" + treaty.RegSeverQueue("backend", DefaultQueue) + "/" + JsonSuffix)); err != nats.ErrStreamNotFound {
+		t.Fatalf("core queue subject should not have a stream, err:%v", err)
+	}
+}
@@ -0,0 +1,553 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/discover"
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+var (
+	ErrLocalNoResponders = errors.New("local rpc no responders")
+)
+
+const localSubBuffer = 1024
+
+// defLocalBus 进程内共享的消息总线,同一进程内所有LocalRpc互通
+var defLocalBus = NewLocalBus()
+
+type localMsg struct {
+	subject  string
+	data     []byte
+	deadline int64
+	reply    chan []byte
+	stream   *StreamReader //流式请求的读取方
+}
+
+type localSub struct {
+	subject  string
+	queue    string
+	dispatch *dispatcher
+	handler  func(msg *localMsg)
+	reject   func(msg *localMsg)
+	msgChan  chan *localMsg
+	done     chan struct{}
+}
+
+func (s *localSub) run() {
+	for {
+		select {
+		case msg := <-s.msgChan:
+			s.dispatch.dispatch(msg.data, func() {
+				s.handler(msg)
+			}, func() {
+				s.reject(msg)
+			})
+		case <-s.done:
+			return
+		}
+	}
+}
+
+type localQueue struct {
+	members []*localSub
+	next    int
+}
+
+// LocalBus 进程内消息总线,支持普通订阅及队列组订阅
+type LocalBus struct {
+	subs   map[string][]*localSub            //subject=>subs
+	queues map[string]map[string]*localQueue //subject=>queue=>members
+	lock   sync.Mutex
+}
+
+func NewLocalBus() *LocalBus {
+	return &LocalBus{
+		subs:   make(map[string][]*localSub),
+		queues: make(map[string]map[string]*localQueue),
+	}
+}
+
+func (b *LocalBus) subscribe(sub *localSub) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(sub.queue) > 0 {
+		groups, ok := b.queues[sub.subject]
+		if !ok {
+			groups = make(map[string]*localQueue)
+			b.queues[sub.subject] = groups
+		}
+		group, ok := groups[sub.queue]
+		if !ok {
+			group = &localQueue{}
+			groups[sub.queue] = group
+		}
+		group.members = append(group.members, sub)
+	} else {
+		b.subs[sub.subject] = append(b.subs[sub.subject], sub)
+	}
+	go sub.run()
+}
+
+func (b *LocalBus) unsubscribe(sub *localSub) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(sub.queue) > 0 {
+		if group, ok := b.queues[sub.subject][sub.queue]; ok {
+			group.members = removeLocalSub(group.members, sub)
+			if len(group.members) == 0 {
+				delete(b.queues[sub.subject], sub.queue)
+			}
+		}
+	} else {
+		b.subs[sub.subject] = removeLocalSub(b.subs[sub.subject], sub)
+		if len(b.subs[sub.subject]) == 0 {
+			delete(b.subs, sub.subject)
+		}
+	}
+	close(sub.done)
+	sub.dispatch.stop()
+}
+
+func removeLocalSub(list []*localSub, sub *localSub) []*localSub {
+	res := make([]*localSub, 0, len(list))
+	for _, v := range list {
+		if v != sub {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// receivers 所有普通订阅及每个队列组轮询选出的一个成员
+func (b *LocalBus) receivers(subject string) []*localSub {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	list := make([]*localSub, 0, len(b.subs[subject]))
+	list = append(list, b.subs[subject]...)
+	for _, group := range b.queues[subject] {
+		if len(group.members) > 0 {
+			list = append(list, group.members[group.next%len(group.members)])
+			group.next++
+		}
+	}
+	return list
+}
+
+func (b *LocalBus) publish(ctx context.Context, msg *localMsg) (int, error) {
+	list := b.receivers(msg.subject)
+	for _, sub := range list {
+		select {
+		case sub.msgChan <- msg:
+		case <-sub.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	return len(list), nil
+}
+
+type LocalRpc struct {
+	ClientChain
+	DialTimeout       time.Duration
+	RpcCoder          map[string]EncoderRpc
+	CompressThreshold int     //消息体超过该字节数时压缩
+	Signer            *Signer //非空时签名及校验rpc帧
+	Server            *treaty.Server
+	DebugMsg          bool
+	Prefix            string
+	Finder            *discover.Finder
+	Bus               *LocalBus
+	subs              []*localSub
+	subLock           sync.Mutex
+}
+
+type LocalRpcOption func(r *LocalRpc)
+
+func WithLocalDebugMsg(debug bool) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.DebugMsg = debug
+	}
+}
+func WithLocalDialTimeout(timeout time.Duration) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.DialTimeout = timeout
+	}
+}
+func WithLocalCompressThreshold(threshold int) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.CompressThreshold = threshold
+	}
+}
+func WithLocalSigner(signer *Signer) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.Signer = signer
+	}
+}
+func WithLocalServer(server *treaty.Server) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.Server = server
+	}
+}
+func WithLocalPrefix(prefix string) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.Prefix = prefix
+	}
+}
+
+// WithLocalBus 使用独立的消息总线,默认使用进程共享总线
+func WithLocalBus(bus *LocalBus) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.Bus = bus
+	}
+}
+func WithLocalClientInterceptors(interceptors ...ClientInterceptor) LocalRpcOption {
+	return func(r *LocalRpc) {
+		r.UseClientInterceptors(interceptors...)
+	}
+}
+
+func NewRpcLocal(opts ...LocalRpcOption) *LocalRpc {
+	r := &LocalRpc{
+		Prefix: "Rpc",
+		Bus:    defLocalBus,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.RpcCoder = newRpcCoders(WithCompressThreshold(r.CompressThreshold), WithSigner(r.Signer))
+	r.Finder = discover.NewFinder()
+	return r
+}
+
+func (r *LocalRpc) RegEncoder(typ string, encoder EncoderRpc) {
+	if _, ok := r.RpcCoder[typ]; !ok {
+		r.RpcCoder[typ] = encoder
+	} else {
+		logger.Fatalf("encoder type has exist:%v", typ)
+	}
+}
+
+func (r *LocalRpc) Find(serverType string, arg any, options ...discover.FilterOption) *treaty.Server {
+	return r.Finder.GetUserServer(serverType, arg, options...)
+}
+
+func (r *LocalRpc) RemoveFindCache(arg any) {
+	r.Finder.RemoveUserCache(arg)
+}
+
+func (r *LocalRpc) GetFinder() *discover.Finder {
+	return r.Finder
+}
+
+func (r *LocalRpc) GetSigner() *Signer {
+	return r.Signer
+}
+
+func (r *LocalRpc) subscribe(s RssBuilder, sub, queue string) error {
+	coder := r.RpcCoder[s.codeType]
+	if coder == nil {
+		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	}
+	d, err := newDispatcher(s)
+	if err != nil {
+		return err
+	}
+	item := &localSub{
+		subject:  sub,
+		queue:    queue,
+		dispatch: d,
+		msgChan:  make(chan *localMsg, localSubBuffer),
+		done:     make(chan struct{}),
+	}
+	item.handler = func(msg *localMsg) {
+		r.DealMsg(msg, s.callback, coder)
+	}
+	item.reject = func(msg *localMsg) {
+		r.respond(msg, overloadResponse(coder, msg.data))
+	}
+	r.subLock.Lock()
+	r.subs = append(r.subs, item)
+	r.subLock.Unlock()
+	r.Bus.subscribe(item)
+	return nil
+}
+
+func (r *LocalRpc) Subscribe(s RssBuilder) error {
+	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+	return r.subscribe(s, sub, "")
+}
+
+func (r *LocalRpc) QueueSubscribe(s RssBuilder) error {
+	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.server.ServerType, s.queue), s.suffix)
+	return r.subscribe(s, sub, s.queue)
+}
+
+func (r *LocalRpc) SubscribeBroadcast(s RssBuilder) error {
+	sub := path.Join(r.Prefix, s.server.ServerType, s.suffix)
+	return r.subscribe(s, sub, "")
+}
+
+func (r *LocalRpc) DealMsg(msg *localMsg, callback CallbackFunc, coder EncoderRpc) {
+	req := &MsgRpc{}
+	err := coder.Decode(msg.data, req)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	ctx, cancel := localContext(msg.deadline)
+	defer cancel()
+	if req.MsgType == MsgTypeStream && msg.stream != nil {
+		req.reply = func(data []byte) error {
+			if !msg.stream.push(data) {
+				return ErrStreamClosed
+			}
+			return nil
+		}
+	}
+	r.respond(msg, callback(req.WithContext(ctx)))
+	if r.DebugMsg {
+		logger.Infof("DealMsg,msgType: %v, msgId: %v", req.MsgType, req.MsgId)
+	}
+}
+
+func (r *LocalRpc) respond(msg *localMsg, resp []byte) {
+	if resp != nil && msg.stream != nil {
+		msg.stream.push(resp)
+	}
+	if resp != nil && msg.reply != nil {
+		select {
+		case msg.reply <- resp:
+		default:
+		}
+	}
+}
+
+func (r *LocalRpc) dialTimeout(s ReqBuilder) time.Duration {
+	if s.dialTimeout > 0 {
+		return s.dialTimeout
+	}
+	return r.DialTimeout
+}
+
+func (r *LocalRpc) request(ctx context.Context, s ReqBuilder, sub string) error {
+	coder := r.RpcCoder[s.codeType]
+	if coder == nil {
+		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	}
+	data, err := coder.Encode(s.message(MsgTypeRequest))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withDialTimeout(ctx, r.dialTimeout(s))
+	defer cancel()
+	msg := &localMsg{subject: sub, data: data, reply: make(chan []byte, 1)}
+	msg.deadline, _ = deadlineNano(ctx)
+	n, err := r.Bus.publish(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLocalNoResponders
+	}
+	select {
+	case resp := <-msg.reply:
+		respMsg := &MsgRpc{MsgData: s.resp}
+		return coder.Decode(resp, respMsg)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *LocalRpc) requestStream(ctx context.Context, s ReqBuilder, sub string) (*StreamReader, error) {
+	coder := r.RpcCoder[s.codeType]
+	if coder == nil {
+		return nil, fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	}
+	data, err := coder.Encode(s.message(MsgTypeStream))
+	if err != nil {
+		return nil, err
+	}
+	reader := newStreamReader(ctx, coder, r.dialTimeout(s), 0)
+	msg := &localMsg{subject: sub, data: data, stream: reader}
+	msg.deadline, _ = deadlineNano(ctx)
+	n, err := r.Bus.publish(ctx, msg)
+	if err == nil && n == 0 {
+		err = ErrLocalNoResponders
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (r *LocalRpc) RequestStream(ctx context.Context, s ReqBuilder) (reader *StreamReader, err error) {
+	err = r.intercept(ctx, MethodRequestStream, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		reader, err = r.requestStream(ctx, s, sub)
+		return err
+	})
+	return
+}
+
+func (r *LocalRpc) QueueRequestStream(ctx context.Context, s ReqBuilder) (reader *StreamReader, err error) {
+	err = r.intercept(ctx, MethodQueueRequestStream, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		reader, err = r.requestStream(ctx, s, sub)
+		return err
+	})
+	return
+}
+
+func (r *LocalRpc) publish(ctx context.Context, s ReqBuilder, sub string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	coder := r.RpcCoder[s.codeType]
+	if coder == nil {
+		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	}
+	data, err := coder.Encode(s.message(MsgTypePublish))
+	if err != nil {
+		return err
+	}
+	msg := &localMsg{subject: sub, data: data}
+	msg.deadline, _ = deadlineNano(ctx)
+	_, err = r.Bus.publish(ctx, msg)
+	return err
+}
+
+func (r *LocalRpc) Request(s ReqBuilder) error {
+	return r.RequestWithContext(context.Background(), s)
+}
+
+func (r *LocalRpc) RequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodRequest, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		return r.request(ctx, s, sub)
+	})
+}
+
+func (r *LocalRpc) QueueRequest(s ReqBuilder) error {
+	return r.QueueRequestWithContext(context.Background(), s)
+}
+
+func (r *LocalRpc) QueueRequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodQueueRequest, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		return r.request(ctx, s, sub)
+	})
+}
+
+func (r *LocalRpc) SendMsg(s ReqBuilder) error {
+	return r.SendMsgWithContext(context.Background(), s)
+}
+
+func (r *LocalRpc) SendMsgWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodSendMsg, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := s.queue
+		if len(s.exName) > 0 && len(s.rtKey) > 0 {
+			sub = path.Join(s.exName, s.rtKey)
+		}
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *LocalRpc) Publish(s ReqBuilder) error {
+	return r.PublishWithContext(context.Background(), s)
+}
+
+func (r *LocalRpc) PublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodPublish, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *LocalRpc) QueuePublish(s ReqBuilder) error {
+	return r.QueuePublishWithContext(context.Background(), s)
+}
+
+func (r *LocalRpc) QueuePublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodQueuePublish, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *LocalRpc) PublishBroadcast(s ReqBuilder) error {
+	return r.PublishBroadcastWithContext(context.Background(), s)
+}
+
+func (r *LocalRpc) PublishBroadcastWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodPublishBroadcast, s, func(ctx context.Context, s ReqBuilder) error {
+		if len(s.serverType) < 1 && s.server != nil {
+			s.serverType = s.server.ServerType
+		}
+		sub := path.Join(r.Prefix, s.serverType, s.suffix)
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *LocalRpc) DecodeMsg(codeType string, data []byte, v any) error {
+	coder := r.RpcCoder[codeType]
+	if coder == nil {
+		return fmt.Errorf("rpc coder not exist:%v", codeType)
+	}
+	return coder.DecodeMsg(data, v)
+}
+
+func (r *LocalRpc) GetCoder(codeType string) EncoderRpc {
+	return r.RpcCoder[codeType]
+}
+
+func (r *LocalRpc) Response(codeType string, v any) []byte {
+	coder := r.RpcCoder[codeType]
+	if coder == nil {
+		logger.Errorf("rpc coder not exist:%v", codeType)
+		return nil
+	}
+	return coder.Response(v)
+}
+
+func (r *LocalRpc) ResponseError(codeType string, err error) []byte {
+	coder := r.RpcCoder[codeType]
+	if coder == nil {
+		logger.Errorf("rpc coder not exist:%v", codeType)
+		return nil
+	}
+	return responseError(coder, err)
+}
+
+func (r *LocalRpc) GetServer() *treaty.Server {
+	return r.Server
+}
+
+func (r *LocalRpc) Close() error {
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	for _, sub := range r.subs {
+		r.Bus.unsubscribe(sub)
+	}
+	r.subs = nil
+	return nil
+}
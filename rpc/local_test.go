@@ -0,0 +1,110 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+// newLocalTestServer 创建订阅自身、队列及广播消息的本地服务
+func newLocalTestServer(t *testing.T, bus *LocalBus, server *treaty.Server, broadcast *int32) *LocalRpc {
+	r := NewRpcLocal(WithLocalBus(bus), WithLocalServer(server), WithLocalDialTimeout(time.Second))
+	h := NewHandler()
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		return &treaty.LoginResponse{Msg: server.ServerId}, nil
+	})
+	h.Register(2, func(req *treaty.LoginRequest) {
+		atomic.AddInt32(broadcast, 1)
+	})
+	callback := func(req *MsgRpc) []byte {
+		resp, err := h.DealMsg(CodeTypeJson, r, req)
+		if err != nil {
+			return r.ResponseError(CodeTypeJson, err)
+		}
+		return resp
+	}
+	b := NewRssBuilder(server).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetCallback(callback)
+	if err := r.Subscribe(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.QueueSubscribe(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SubscribeBroadcast(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestLocalRpc(t *testing.T) {
+	bus := NewLocalBus()
+	var broadcast int32
+	s1 := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	s2 := &treaty.Server{ServerId: "1002", ServerType: "backend"}
+	r1 := newLocalTestServer(t, bus, s1, &broadcast)
+	r2 := newLocalTestServer(t, bus, s2, &broadcast)
+	defer r2.Close()
+	client := NewRpcLocal(WithLocalBus(bus), WithLocalDialTimeout(time.Second))
+	ctx := context.Background()
+	//指定服务器请求
+	resp, err := CallWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, s2, 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+	if err != nil || resp.Msg != s2.ServerId {
+		t.Fatalf("request failed, err:%v, resp:%+v", err, resp)
+	}
+	//队列请求轮询
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		resp, err = CallQueueWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, "backend", 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[resp.Msg] = true
+	}
+	if len(seen) != 2 {
+		t.Fatalf("queue request not balanced:%v", seen)
+	}
+	//远程错误
+	_, err = CallWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, s1, 1, &treaty.LoginRequest{}, WithCallJson())
+	if re, ok := AsRemoteError(err); !ok || re.Code != 1001 {
+		t.Fatalf("want remote error, got:%v", err)
+	}
+	//广播
+	req := DefaultReqBuilder().SetServerType("backend").SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetMsgId(2).SetReq(&treaty.LoginRequest{}).Build()
+	if err = client.PublishBroadcast(req); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&broadcast) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&broadcast); n != 2 {
+		t.Fatalf("broadcast count not match:%v", n)
+	}
+	//关闭后无响应
+	if err = r1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = CallWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, s1, 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+	if !errors.Is(err, ErrLocalNoResponders) {
+		t.Fatalf("want no responders, got:%v", err)
+	}
+}
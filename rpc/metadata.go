@@ -0,0 +1,158 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// 常用元数据key
+const (
+	MetaCaller    = "caller"     //调用方服务器ID
+	MetaUid       = "uid"        //用户ID
+	MetaRequestId = "req_id"     //请求ID
+	MetaTraceId   = "trace_id"   //链路追踪ID
+	MetaLocale    = "locale"     //语言
+	MetaIdemKey   = "idem_key"   //幂等键
+	MetaStreamSeq = "stream_seq" //流式响应分片编号
+)
+
+const (
+	metaVersion    = 0x01 //当前元数据版本
+	metaHeadLength = 0x03 //版本1字节 + 长度2字节
+)
+
+// Metadata rpc消息元数据
+type Metadata map[string]string
+
+func (m Metadata) Get(key string) string {
+	return m[key]
+}
+
+func (m Metadata) Caller() string {
+	return m[MetaCaller]
+}
+
+func (m Metadata) Uid() int64 {
+	uid, _ := strconv.ParseInt(m[MetaUid], 10, 64)
+	return uid
+}
+
+func (m Metadata) RequestId() string {
+	return m[MetaRequestId]
+}
+
+func (m Metadata) TraceId() string {
+	return m[MetaTraceId]
+}
+
+func (m Metadata) IdempotencyKey() string {
+	return m[MetaIdemKey]
+}
+
+func (m Metadata) Locale() string {
+	return m[MetaLocale]
+}
+
+func (m Metadata) clone() Metadata {
+	if len(m) == 0 {
+		return nil
+	}
+	res := make(Metadata, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+type metaCtxKey struct{}
+
+// WithMetadata 将元数据放入上下文
+func WithMetadata(ctx context.Context, meta Metadata) context.Context {
+	if len(meta) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, metaCtxKey{}, meta)
+}
+
+// MetadataFromContext 从处理器上下文获取元数据
+func MetadataFromContext(ctx context.Context) Metadata {
+	if meta, ok := ctx.Value(metaCtxKey{}).(Metadata); ok {
+		return meta
+	}
+	return nil
+}
+
+// Metadata section
+// --version--|--<length>--|--klen--|-key-|--vlen--|-val-|...
+// ---1 byte--|---2 byte---|-1 byte-|-----|-2 byte-|-----|...
+func encodeMetadata(meta Metadata) ([]byte, error) {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf := make([]byte, metaHeadLength)
+	buf[0] = metaVersion
+	for _, k := range keys {
+		v := meta[k]
+		if len(k) > 0xFF || len(v) > 0xFFFF {
+			return nil, ErrInvalidMessage
+		}
+		buf = append(buf, byte(len(k)))
+		buf = append(buf, k...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		buf = append(buf, v...)
+	}
+	length := len(buf) - metaHeadLength
+	if length > 0xFFFF {
+		return nil, ErrInvalidMessage
+	}
+	binary.BigEndian.PutUint16(buf[1:metaHeadLength], uint16(length))
+	return buf, nil
+}
+
+// decodeMetadata 返回元数据及元数据段总长度,未知版本跳过元数据段
+func decodeMetadata(data []byte) (Metadata, int, error) {
+	if len(data) < metaHeadLength {
+		return nil, 0, ErrInvalidMessage
+	}
+	version := data[0]
+	total := metaHeadLength + int(binary.BigEndian.Uint16(data[1:metaHeadLength]))
+	if len(data) < total {
+		return nil, 0, ErrInvalidMessage
+	}
+	if version != metaVersion {
+		return nil, total, nil
+	}
+	meta := make(Metadata)
+	body := data[metaHeadLength:total]
+	for len(body) > 0 {
+		kl := int(body[0])
+		if len(body) < 1+kl+2 {
+			return nil, 0, ErrInvalidMessage
+		}
+		key := string(body[1 : 1+kl])
+		body = body[1+kl:]
+		vl := int(binary.BigEndian.Uint16(body[:2]))
+		if len(body) < 2+vl {
+			return nil, 0, ErrInvalidMessage
+		}
+		meta[key] = string(body[2 : 2+vl])
+		body = body[2+vl:]
+	}
+	return meta, total, nil
+}
@@ -0,0 +1,237 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetricsBuckets 耗时直方图默认分桶,单位秒
+var DefaultMetricsBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+const (
+	metricsSideClient = "client"
+	metricsSideServer = "server"
+)
+
+type metricsKey struct {
+	side       string
+	method     string
+	msgId      int32
+	codeType   string
+	serverType string
+}
+
+// labels prometheus标签,服务端指标不区分出站方法
+func (k metricsKey) labels() string {
+	var b strings.Builder
+	if k.side == metricsSideClient {
+		b.WriteString(`method="` + k.method + `",`)
+	}
+	b.WriteString(`msg_id="` + strconv.Itoa(int(k.msgId)) + `",`)
+	b.WriteString(`code_type="` + k.codeType + `",`)
+	b.WriteString(`server_type="` + k.serverType + `"`)
+	return b.String()
+}
+
+type metricsSeries struct {
+	calls    uint64
+	errors   uint64
+	timeouts uint64
+	buckets  []uint64 //各分桶累计计数
+	sum      float64
+}
+
+// RpcMetrics 按msgId、编码类型及目标服务器类型统计调用次数、错误、超时及耗时
+type RpcMetrics struct {
+	serverType string //服务端指标的server_type标签,即本服务器类型
+	buckets    []float64
+	series     map[metricsKey]*metricsSeries
+	lock       sync.Mutex
+	clients    sync.Map //已安装出站统计的rpc实例
+}
+
+// NewRpcMetrics serverType为本服务器类型,buckets为空时使用默认分桶
+func NewRpcMetrics(serverType string, buckets ...float64) *RpcMetrics {
+	if len(buckets) < 1 {
+		buckets = DefaultMetricsBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &RpcMetrics{
+		serverType: serverType,
+		buckets:    buckets,
+		series:     make(map[metricsKey]*metricsSeries),
+	}
+}
+
+// isTimeout 本地截止时间到达或远程判定请求已过期
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	re, ok := AsRemoteError(err)
+	return ok && re.Code == ErrCodeExpired
+}
+
+func (m *RpcMetrics) observe(key metricsKey, cost time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	item, ok := m.series[key]
+	if !ok {
+		item = &metricsSeries{buckets: make([]uint64, len(m.buckets))}
+		m.series[key] = item
+	}
+	item.calls++
+	if err != nil {
+		if isTimeout(err) {
+			item.timeouts++
+		} else {
+			item.errors++
+		}
+	}
+	seconds := cost.Seconds()
+	item.sum += seconds
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			item.buckets[i]++
+		}
+	}
+}
+
+// ObserveClient 记录一次出站调用
+func (m *RpcMetrics) ObserveClient(method string, msgId int32, codeType, serverType string, cost time.Duration, err error) {
+	m.observe(metricsKey{side: metricsSideClient, method: method, msgId: msgId, codeType: codeType, serverType: serverType}, cost, err)
+}
+
+// ObserveServer 记录一次入站处理
+func (m *RpcMetrics) ObserveServer(msgId int32, codeType string, cost time.Duration, err error) {
+	m.observe(metricsKey{side: metricsSideServer, msgId: msgId, codeType: codeType, serverType: m.serverType}, cost, err)
+}
+
+// ClientInterceptor 出站调用统计拦截器,流式调用仅统计建立耗时
+func (m *RpcMetrics) ClientInterceptor() ClientInterceptor {
+	return func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+		begin := time.Now()
+		err := next(ctx, s)
+		m.ObserveClient(method, s.msgId, s.codeType, s.serverType, time.Since(begin), err)
+		return err
+	}
+}
+
+// installClient 为rpc安装出站统计拦截器,同一rpc实例只安装一次
+func (m *RpcMetrics) installClient(r ServerRpc) {
+	if _, ok := m.clients.LoadOrStore(r, struct{}{}); !ok {
+		r.UseClientInterceptors(m.ClientInterceptor())
+	}
+}
+
+// WriteTo 以prometheus文本格式输出全部指标
+func (m *RpcMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	keys := make([]metricsKey, 0, len(m.series))
+	series := make(map[metricsKey]metricsSeries, len(m.series))
+	for k, v := range m.series {
+		keys = append(keys, k)
+		item := *v
+		item.buckets = append([]uint64(nil), v.buckets...)
+		series[k] = item
+	}
+	m.lock.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.side != b.side {
+			return a.side < b.side
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		if a.msgId != b.msgId {
+			return a.msgId < b.msgId
+		}
+		if a.codeType != b.codeType {
+			return a.codeType < b.codeType
+		}
+		return a.serverType < b.serverType
+	})
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, side := range []string{metricsSideClient, metricsSideServer} {
+		counters := []struct {
+			name, help string
+			value      func(s metricsSeries) uint64
+		}{
+			{"calls_total", "rpc " + side + " calls", func(s metricsSeries) uint64 { return s.calls }},
+			{"errors_total", "rpc " + side + " calls failed", func(s metricsSeries) uint64 { return s.errors }},
+			{"timeouts_total", "rpc " + side + " calls timed out", func(s metricsSeries) uint64 { return s.timeouts }},
+		}
+		for _, c := range counters {
+			name := "kungfu_rpc_" + side + "_" + c.name
+			fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s counter\n", name, c.help, name)
+			for _, k := range keys {
+				if k.side == side {
+					fmt.Fprintf(cw, "%s{%s} %d\n", name, k.labels(), c.value(series[k]))
+				}
+			}
+		}
+		name := "kungfu_rpc_" + side + "_duration_seconds"
+		fmt.Fprintf(cw, "# HELP %s rpc %s call latency\n# TYPE %s histogram\n", name, side, name)
+		for _, k := range keys {
+			if k.side != side {
+				continue
+			}
+			s, labels := series[k], k.labels()
+			for i, bound := range m.buckets {
+				fmt.Fprintf(cw, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), s.buckets[i])
+			}
+			fmt.Fprintf(cw, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, s.calls)
+			fmt.Fprintf(cw, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(s.sum, 'g', -1, 64))
+			fmt.Fprintf(cw, "%s_count{%s} %d\n", name, labels, s.calls)
+		}
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP prometheus抓取接口
+func (m *RpcMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
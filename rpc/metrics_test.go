@@ -0,0 +1,71 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestRpcMetrics(t *testing.T) {
+	metrics := NewRpcMetrics("hall", 0.01, 1)
+	h, r := NewHandler(), newHandlerTestRpc()
+	h.UseMetrics(metrics)
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		return &treaty.LoginResponse{Msg: "ok"}, nil
+	})
+	for _, uid := range []int32{1, 1, 0} {
+		_ = r.request(h, 1, &treaty.LoginRequest{Uid: uid}, &treaty.LoginResponse{})
+	}
+	metrics.ObserveClient(MethodRequest, 2, CodeTypeProto, "game", 2*time.Second, context.DeadlineExceeded)
+	metrics.ObserveClient(MethodRequest, 2, CodeTypeProto, "game", time.Millisecond, nil)
+
+	var b strings.Builder
+	if _, err := metrics.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE kungfu_rpc_server_calls_total counter",
+		`kungfu_rpc_server_calls_total{msg_id="1",code_type="json",server_type="hall"} 3`,
+		`kungfu_rpc_server_errors_total{msg_id="1",code_type="json",server_type="hall"} 1`,
+		`kungfu_rpc_client_timeouts_total{method="Request",msg_id="2",code_type="proto",server_type="game"} 1`,
+		`kungfu_rpc_client_errors_total{method="Request",msg_id="2",code_type="proto",server_type="game"} 0`,
+		`kungfu_rpc_client_duration_seconds_bucket{method="Request",msg_id="2",code_type="proto",server_type="game",le="0.01"} 1`,
+		`kungfu_rpc_client_duration_seconds_bucket{method="Request",msg_id="2",code_type="proto",server_type="game",le="1"} 1`,
+		`kungfu_rpc_client_duration_seconds_bucket{method="Request",msg_id="2",code_type="proto",server_type="game",le="+Inf"} 2`,
+		`kungfu_rpc_client_duration_seconds_count{method="Request",msg_id="2",code_type="proto",server_type="game"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRpcMetricsInstallOnce(t *testing.T) {
+	metrics := NewRpcMetrics("hall")
+	r := NewRpcLocal(WithLocalBus(NewLocalBus()))
+	defer r.Close()
+	metrics.installClient(r)
+	metrics.installClient(r)
+	if n := len(r.loadClientInterceptors()); n != 1 {
+		t.Fatalf("metrics interceptor installed %v times", n)
+	}
+}
@@ -13,30 +13,38 @@
 package rpc
 
 import (
+	"context"
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fengyuqin/kungfu/v2/discover"
-	"github.com/fengyuqin/kungfu/v2/serialize"
 
 	"github.com/fengyuqin/kungfu/v2/logger"
 	"github.com/fengyuqin/kungfu/v2/treaty"
-	"github.com/fengyuqin/kungfu/v2/utils"
 	"github.com/nats-io/nats.go"
 )
 
 type NatsRpc struct {
-	Endpoints   []string
-	Options     []nats.Option
-	Client      *nats.Conn
-	DialTimeout time.Duration
-	RpcCoder    map[string]EncoderRpc
-	Server      *treaty.Server
-	DebugMsg    bool
-	Prefix      string
-	Finder      *discover.Finder
+	ClientChain
+	deadLetterBox
+	Endpoints         []string
+	Options           []nats.Option
+	Client            *nats.Conn
+	DialTimeout       time.Duration
+	RpcCoder          map[string]EncoderRpc
+	CompressThreshold int     //消息体超过该字节数时压缩
+	Signer            *Signer //非空时签名及校验rpc帧
+	Server            *treaty.Server
+	DebugMsg          bool
+	Prefix            string
+	Finder            *discover.Finder
+	JetStream         nats.JetStreamContext //持久化队列使用
+	streams           sync.Map              //已确认存在的stream
+	dispatchers       dispatcherSet
 }
 type NatsRpcOption func(r *NatsRpc)
 
@@ -55,6 +63,16 @@ func WithNatsDialTimeout(timeout time.Duration) NatsRpcOption {
 		r.DialTimeout = timeout
 	}
 }
+func WithNatsCompressThreshold(threshold int) NatsRpcOption {
+	return func(r *NatsRpc) {
+		r.CompressThreshold = threshold
+	}
+}
+func WithNatsSigner(signer *Signer) NatsRpcOption {
+	return func(r *NatsRpc) {
+		r.Signer = signer
+	}
+}
 func WithNatsServer(server *treaty.Server) NatsRpcOption {
 	return func(r *NatsRpc) {
 		r.Server = server
@@ -71,6 +89,12 @@ func WithNatsPrefix(prefix string) NatsRpcOption {
 	}
 }
 
+func WithNatsClientInterceptors(interceptors ...ClientInterceptor) NatsRpcOption {
+	return func(r *NatsRpc) {
+		r.UseClientInterceptors(interceptors...)
+	}
+}
+
 func NewRpcNats(opts ...NatsRpcOption) *NatsRpc {
 	r := &NatsRpc{
 		Prefix: "Rpc",
@@ -84,10 +108,10 @@ func NewRpcNats(opts ...NatsRpcOption) *NatsRpc {
 		logger.Fatal(err)
 	}
 	r.Client = conn
-	r.RpcCoder = map[string]EncoderRpc{
-		CodeTypeProto: NewRpcEncoder(serialize.NewProtoSerializer()),
-		CodeTypeJson:  NewRpcEncoder(serialize.NewJsonSerializer()),
+	if r.JetStream, err = conn.JetStream(); err != nil {
+		logger.Fatal(err)
 	}
+	r.RpcCoder = newRpcCoders(WithCompressThreshold(r.CompressThreshold), WithSigner(r.Signer))
 	r.Finder = discover.NewFinder()
 	return r
 }
@@ -108,6 +132,14 @@ func (r *NatsRpc) RemoveFindCache(arg any) {
 	r.Finder.RemoveUserCache(arg)
 }
 
+func (r *NatsRpc) GetFinder() *discover.Finder {
+	return r.Finder
+}
+
+func (r *NatsRpc) GetSigner() *Signer {
+	return r.Signer
+}
+
 func (r *NatsRpc) prepare(s RssBuilder) (EncoderRpc, error) {
 	coder := r.RpcCoder[s.codeType]
 	if coder == nil {
@@ -122,20 +154,12 @@ func (r *NatsRpc) Subscribe(s RssBuilder) error {
 		return err
 	}
 	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
-	if _, err = r.Client.Subscribe(sub, func(msg *nats.Msg) {
-		if s.parallel {
-			go utils.SafeRun(func() {
-				r.DealMsg(msg, s.callback, coder)
-			})
-		} else {
-			utils.SafeRun(func() {
-				r.DealMsg(msg, s.callback, coder)
-			})
-		}
-	}); err != nil {
+	handler, err := r.msgHandler(s, sub, coder)
+	if err != nil {
 		return err
 	}
-	return nil
+	_, err = r.Client.Subscribe(sub, handler)
+	return err
 }
 
 func (r *NatsRpc) QueueSubscribe(s RssBuilder) error {
@@ -144,20 +168,15 @@ func (r *NatsRpc) QueueSubscribe(s RssBuilder) error {
 		return err
 	}
 	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.server.ServerType, s.queue), s.suffix)
-	if _, err = r.Client.QueueSubscribe(sub, s.queue, func(msg *nats.Msg) {
-		if s.parallel {
-			go utils.SafeRun(func() {
-				r.DealMsg(msg, s.callback, coder)
-			})
-		} else {
-			utils.SafeRun(func() {
-				r.DealMsg(msg, s.callback, coder)
-			})
-		}
-	}); err != nil {
+	if s.durable {
+		return r.durableSubscribe(s, sub, coder)
+	}
+	handler, err := r.msgHandler(s, sub, coder)
+	if err != nil {
 		return err
 	}
-	return nil
+	_, err = r.Client.QueueSubscribe(sub, s.queue, handler)
+	return err
 }
 
 func (r *NatsRpc) SubscribeBroadcast(s RssBuilder) error {
@@ -166,20 +185,32 @@ func (r *NatsRpc) SubscribeBroadcast(s RssBuilder) error {
 		return err
 	}
 	sub := path.Join(r.Prefix, s.server.ServerType, s.suffix)
-	if _, err = r.Client.Subscribe(sub, func(msg *nats.Msg) {
-		if s.parallel {
-			go utils.SafeRun(func() {
-				r.DealMsg(msg, s.callback, coder)
-			})
-		} else {
-			utils.SafeRun(func() {
-				r.DealMsg(msg, s.callback, coder)
-			})
-		}
-	}); err != nil {
+	handler, err := r.msgHandler(s, sub, coder)
+	if err != nil {
 		return err
 	}
-	return nil
+	_, err = r.Client.Subscribe(sub, handler)
+	return err
+}
+
+// msgHandler 按订阅的并发设置分发消息,过载时请求直接回复错误
+func (r *NatsRpc) msgHandler(s RssBuilder, sub string, coder EncoderRpc) (nats.MsgHandler, error) {
+	d, err := r.dispatchers.add(s)
+	if err != nil {
+		return nil, err
+	}
+	r.addDeadLetterRoute("", sub, s, coder)
+	return func(msg *nats.Msg) {
+		d.dispatch(msg.Data, func() {
+			r.DealMsg(msg, s.callback, coder)
+		}, func() {
+			if resp := overloadResponse(coder, msg.Data); resp != nil && len(msg.Reply) > 0 {
+				if err := msg.Respond(resp); err != nil {
+					logger.Error(err)
+				}
+			}
+		})
+	}, nil
 }
 
 func (r *NatsRpc) DealMsg(msg *nats.Msg, callback CallbackFunc, coder EncoderRpc) {
@@ -187,9 +218,16 @@ func (r *NatsRpc) DealMsg(msg *nats.Msg, callback CallbackFunc, coder EncoderRpc
 	err := coder.Decode(msg.Data, req)
 	if err != nil {
 		logger.Error(err)
+		r.putDeadLetter("nats", "", msg.Subject, msg.Data, DeadReasonDecode, err.Error(), nil)
 		return
 	}
-	resp := callback(req)
+	defer r.guardDeadLetter("nats", "", msg.Subject, msg.Data)
+	ctx, cancel := msgContext(msg.Header.Get(HeaderTimeout))
+	defer cancel()
+	if req.MsgType == MsgTypeStream && len(msg.Reply) > 0 {
+		req.reply = msg.Respond
+	}
+	resp := callback(req.WithContext(ctx))
 	if resp != nil {
 		if err = msg.Respond(resp); err != nil {
 			logger.Error(err)
@@ -206,118 +244,180 @@ func (r *NatsRpc) dialTimeout(s ReqBuilder) time.Duration {
 	return r.DialTimeout
 }
 
-func (r *NatsRpc) Request(s ReqBuilder) error {
+// newMsg 创建消息,剩余超时放入消息头
+func (r *NatsRpc) newMsg(ctx context.Context, sub string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(sub)
+	msg.Data = data
+	if timeout, ok := timeoutNano(ctx); ok {
+		msg.Header.Set(HeaderTimeout, strconv.FormatInt(timeout, 10))
+	}
+	return msg
+}
+
+func (r *NatsRpc) request(ctx context.Context, s ReqBuilder, sub string) error {
 	coder := r.RpcCoder[s.codeType]
 	if coder == nil {
 		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
 	}
-	var msg *nats.Msg
-	var err error
-	var data []byte
-	data, err = r.EncodeMsg(coder, MsgTypeRequest, s.msgId, s.req)
+	data, err := coder.Encode(s.message(MsgTypeRequest))
 	if err != nil {
 		return err
 	}
-	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
-	if msg, err = r.Client.Request(sub, data, r.dialTimeout(s)); err == nil {
-		respMsg := &MsgRpc{MsgData: s.resp}
-		err = coder.Decode(msg.Data, respMsg)
-		if err != nil {
-			return err
-		}
-	} else {
+	ctx, cancel := withDialTimeout(ctx, r.dialTimeout(s))
+	defer cancel()
+	msg, err := r.Client.RequestMsgWithContext(ctx, r.newMsg(ctx, sub, data))
+	if err != nil {
 		return err
 	}
-	return nil
+	respMsg := &MsgRpc{MsgData: s.resp}
+	return coder.Decode(msg.Data, respMsg)
 }
-func (r *NatsRpc) QueueRequest(s ReqBuilder) error {
+
+// requestStream 每个流使用独立的回复主题,服务端依次回复分片
+func (r *NatsRpc) requestStream(ctx context.Context, s ReqBuilder, sub string) (*StreamReader, error) {
 	coder := r.RpcCoder[s.codeType]
 	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+		return nil, fmt.Errorf("rpc coder not exist:%v", s.codeType)
 	}
-	var msg *nats.Msg
-	var err error
-	var data []byte
-	data, err = r.EncodeMsg(coder, MsgTypeRequest, s.msgId, s.req)
+	data, err := coder.Encode(s.message(MsgTypeStream))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
-	if msg, err = r.Client.Request(sub, data, r.dialTimeout(s)); err == nil {
-		respMsg := &MsgRpc{MsgData: s.resp}
-		err = coder.Decode(msg.Data, respMsg)
-		if err != nil {
-			return err
+	reader := newStreamReader(ctx, coder, r.dialTimeout(s), 0)
+	inbox := nats.NewInbox()
+	subscription, err := r.Client.Subscribe(inbox, func(msg *nats.Msg) {
+		//无订阅者时服务端回复503状态
+		if len(msg.Data) == 0 && msg.Header.Get("Status") == "503" {
+			reader.fail(nats.ErrNoResponders)
+			return
 		}
-	} else {
-		return err
-	}
-	return nil
-}
-func (r *NatsRpc) SendMsg(s ReqBuilder) error {
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
+		if !reader.push(msg.Data) || streamFrameDone(msg.Data) {
+			reader.Close()
+		}
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	sub := s.queue
-	if len(s.exName) > 0 && len(s.rtKey) > 0 {
-		sub = path.Join(s.exName, s.rtKey)
+	reader.closer = func() {
+		if err := subscription.Unsubscribe(); err != nil {
+			logger.Error(err)
+		}
 	}
-	if err = r.Client.Publish(sub, data); err != nil {
-		return err
+	msg := r.newMsg(ctx, sub, data)
+	msg.Reply = inbox
+	if err = r.Client.PublishMsg(msg); err != nil {
+		reader.Close()
+		return nil, err
 	}
-	return nil
+	return reader, nil
 }
-func (r *NatsRpc) Publish(s ReqBuilder) error {
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
-	if err != nil {
+
+func (r *NatsRpc) RequestStream(ctx context.Context, s ReqBuilder) (reader *StreamReader, err error) {
+	err = r.intercept(ctx, MethodRequestStream, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		reader, err = r.requestStream(ctx, s, sub)
 		return err
-	}
-	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
-	if err = r.Client.Publish(sub, data); err != nil {
+	})
+	return
+}
+
+func (r *NatsRpc) QueueRequestStream(ctx context.Context, s ReqBuilder) (reader *StreamReader, err error) {
+	err = r.intercept(ctx, MethodQueueRequestStream, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		reader, err = r.requestStream(ctx, s, sub)
 		return err
-	}
-	return nil
+	})
+	return
 }
 
-func (r *NatsRpc) QueuePublish(s ReqBuilder) error {
+func (r *NatsRpc) publish(ctx context.Context, s ReqBuilder, sub string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	coder := r.RpcCoder[s.codeType]
 	if coder == nil {
 		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
 	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
+	data, err := coder.Encode(s.message(MsgTypePublish))
 	if err != nil {
 		return err
 	}
-	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
-	if err = r.Client.Publish(sub, data); err != nil {
-		return err
-	}
-	return nil
+	return r.Client.PublishMsg(r.newMsg(ctx, sub, data))
+}
+
+func (r *NatsRpc) Request(s ReqBuilder) error {
+	return r.RequestWithContext(context.Background(), s)
+}
+
+func (r *NatsRpc) RequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodRequest, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		return r.request(ctx, s, sub)
+	})
+}
+
+func (r *NatsRpc) QueueRequest(s ReqBuilder) error {
+	return r.QueueRequestWithContext(context.Background(), s)
+}
+
+func (r *NatsRpc) QueueRequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodQueueRequest, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		return r.request(ctx, s, sub)
+	})
+}
+
+func (r *NatsRpc) SendMsg(s ReqBuilder) error {
+	return r.SendMsgWithContext(context.Background(), s)
+}
+
+func (r *NatsRpc) SendMsgWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodSendMsg, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := s.queue
+		if len(s.exName) > 0 && len(s.rtKey) > 0 {
+			sub = path.Join(s.exName, s.rtKey)
+		}
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *NatsRpc) Publish(s ReqBuilder) error {
+	return r.PublishWithContext(context.Background(), s)
+}
+
+func (r *NatsRpc) PublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodPublish, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *NatsRpc) QueuePublish(s ReqBuilder) error {
+	return r.QueuePublishWithContext(context.Background(), s)
+}
+
+func (r *NatsRpc) QueuePublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodQueuePublish, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		if s.durable {
+			return r.durablePublish(ctx, s, sub)
+		}
+		return r.publish(ctx, s, sub)
+	})
 }
 
 func (r *NatsRpc) PublishBroadcast(s ReqBuilder) error {
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
-	if err != nil {
-		return err
-	}
-	if len(s.serverType) < 1 && s.server != nil {
-		s.serverType = s.server.ServerType
-	}
-	sub := path.Join(r.Prefix, s.serverType, s.suffix)
-	return r.Client.Publish(sub, data)
+	return r.PublishBroadcastWithContext(context.Background(), s)
+}
+
+func (r *NatsRpc) PublishBroadcastWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodPublishBroadcast, s, func(ctx context.Context, s ReqBuilder) error {
+		if len(s.serverType) < 1 && s.server != nil {
+			s.serverType = s.server.ServerType
+		}
+		sub := path.Join(r.Prefix, s.serverType, s.suffix)
+		return r.publish(ctx, s, sub)
+	})
 }
 
 func (r *NatsRpc) EncodeMsg(coder EncoderRpc, msgType MessageType, msgId int32, req any) ([]byte, error) {
@@ -354,10 +454,20 @@ func (r *NatsRpc) Response(codeType string, v any) []byte {
 	return coder.Response(v)
 }
 
+func (r *NatsRpc) ResponseError(codeType string, err error) []byte {
+	coder := r.RpcCoder[codeType]
+	if coder == nil {
+		logger.Errorf("rpc coder not exist:%v", codeType)
+		return nil
+	}
+	return responseError(coder, err)
+}
+
 func (r *NatsRpc) GetServer() *treaty.Server {
 	return r.Server
 }
 
 func (r *NatsRpc) Close() error {
+	r.dispatchers.stop()
 	return nil
 }
@@ -12,6 +12,8 @@
 
 package rpc
 
+import "time"
+
 type Option func(b *ServerBase)
 
 func WithSelfEventHandler(handler CallbackFunc) Option {
@@ -32,6 +34,27 @@ func WithInnerMsgHandler(handler MsgHandler) Option {
 	}
 }
 
+// WithInterceptors 消息处理拦截器,作用于自身及广播事件
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(b *ServerBase) {
+		b.interceptors = append(b.interceptors, interceptors...)
+	}
+}
+
+// WithClientInterceptors 出站调用拦截器,作用于服务器的rpc实例
+func WithClientInterceptors(interceptors ...ClientInterceptor) Option {
+	return func(b *ServerBase) {
+		b.clientInterceptors = append(b.clientInterceptors, interceptors...)
+	}
+}
+
+// WithIdempotency 启用请求幂等,store通常为stores.GetDefStoreKeeper()
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(b *ServerBase) {
+		b.idempotency = newIdempotency(store, ttl)
+	}
+}
+
 func WithPlugin(plugin ServerPlugin) Option {
 	return func(b *ServerBase) {
 		b.plugins = append(b.plugins, plugin)
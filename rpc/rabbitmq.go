@@ -18,12 +18,12 @@ import (
 	"fmt"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fengyuqin/kungfu/v2/discover"
 	"github.com/fengyuqin/kungfu/v2/logger"
 	"github.com/fengyuqin/kungfu/v2/pool"
-	"github.com/fengyuqin/kungfu/v2/serialize"
 	"github.com/fengyuqin/kungfu/v2/treaty"
 	"github.com/fengyuqin/kungfu/v2/utils"
 	"github.com/google/uuid"
@@ -34,21 +34,39 @@ var (
 	ErrorClosed  = errors.New("rabbitmq closed connection")
 	ErrorBlocked = errors.New("rabbitmq blocked")
 	ErrorTimeout = errors.New("rabbitmq publish timeout")
+	//连接断开等待重连,未完成的请求以该错误结束
+	ErrRabbitDisconnected = errors.New("rabbitmq disconnected, reconnecting")
+)
+
+const (
+	DefaultRabbitReconnectMin = 500 * time.Millisecond
+	DefaultRabbitReconnectMax = 30 * time.Second
 )
 
 type RabbitWaitItem struct {
 	CorrId   string
 	CodeType string
 	MsgData  any
-	MsgReply chan *MsgRpc
+	Stream   *StreamReader     //流式请求,收到结束帧后移除
+	reply    chan *rabbitReply //普通请求的回复
+}
+
+// rabbitReply 回复消息及解析错误
+type rabbitReply struct {
+	msg *MsgRpc
+	err error
 }
 
 type RabbitReplyQueue struct {
 	QueueName string
 	WaitMap   map[string]*RabbitWaitItem
 	WaitChan  chan *RabbitWaitItem
+	DoneChan  chan string //读取方放弃的流式请求
 	ReplyChan <-chan amqp.Delivery
 	RpcCoder  map[string]EncoderRpc
+	conn      *amqp.Connection
+	done      chan struct{} //连接断开后关闭
+	onClose   func()
 }
 
 func NewRabbitReplyQueue(name string, ch <-chan amqp.Delivery, rpcCoder map[string]EncoderRpc) *RabbitReplyQueue {
@@ -56,8 +74,77 @@ func NewRabbitReplyQueue(name string, ch <-chan amqp.Delivery, rpcCoder map[stri
 		QueueName: name,
 		WaitMap:   make(map[string]*RabbitWaitItem),
 		WaitChan:  make(chan *RabbitWaitItem, 30),
+		DoneChan:  make(chan string, 30),
 		ReplyChan: ch,
 		RpcCoder:  rpcCoder,
+		done:      make(chan struct{}),
+	}
+}
+
+// wait 登记等待回复,回复队列已断开时返回ErrRabbitDisconnected
+func (r *RabbitReplyQueue) wait(item *RabbitWaitItem) error {
+	select {
+	case <-r.done:
+		return ErrRabbitDisconnected
+	default:
+	}
+	select {
+	case r.WaitChan <- item:
+		return nil
+	case <-r.done:
+		return ErrRabbitDisconnected
+	}
+}
+
+// cancel 移除未收到回复的等待项,队列繁忙时异步移除,避免阻塞回复协程
+func (r *RabbitReplyQueue) cancel(corrId string) {
+	select {
+	case r.DoneChan <- corrId:
+	default:
+		go func() {
+			select {
+			case r.DoneChan <- corrId:
+			case <-r.done:
+			}
+		}()
+	}
+}
+
+// register 登记已提交的等待项,移除前调用,确保先登记后移除
+func (r *RabbitReplyQueue) register() {
+	for {
+		select {
+		case item := <-r.WaitChan:
+			r.WaitMap[item.CorrId] = item
+		default:
+			return
+		}
+	}
+}
+
+// failAll 以err结束所有等待中的请求
+func (r *RabbitReplyQueue) failAll(err error) {
+	fail := func(item *RabbitWaitItem) {
+		if item.Stream != nil {
+			item.Stream.fail(err)
+			return
+		}
+		select {
+		case item.reply <- &rabbitReply{err: err}:
+		default:
+		}
+	}
+	for corrId, item := range r.WaitMap {
+		fail(item)
+		delete(r.WaitMap, corrId)
+	}
+	for {
+		select {
+		case item := <-r.WaitChan:
+			fail(item)
+		default:
+			return
+		}
 	}
 }
 
@@ -67,8 +154,28 @@ func (r *RabbitReplyQueue) WaitReply() {
 			select {
 			case item := <-r.WaitChan:
 				r.WaitMap[item.CorrId] = item
-			case reply := <-r.ReplyChan:
+			case corrId := <-r.DoneChan:
+				r.register()
+				delete(r.WaitMap, corrId)
+			case reply, ok := <-r.ReplyChan:
+				//连接断开,结束等待中的请求,下次请求时重新创建回复队列
+				if !ok {
+					logger.Warnf("WaitReply reply queue closed, queue:%v", r.QueueName)
+					close(r.done)
+					r.failAll(ErrRabbitDisconnected)
+					if r.onClose != nil {
+						r.onClose()
+					}
+					return
+				}
+				r.register()
 				if v, ok := r.WaitMap[reply.CorrelationId]; ok {
+					if v.Stream != nil {
+						if !v.Stream.offer(reply.Body) || streamFrameDone(reply.Body) {
+							delete(r.WaitMap, reply.CorrelationId)
+						}
+						continue
+					}
 					coder := r.RpcCoder[v.CodeType]
 					if coder == nil {
 						logger.Errorf("rpc coder not exist:%v", v.CodeType)
@@ -76,10 +183,10 @@ func (r *RabbitReplyQueue) WaitReply() {
 					}
 					respMsg := &MsgRpc{MsgData: v.MsgData}
 					err := coder.Decode(reply.Body, respMsg)
-					if err != nil {
+					if _, ok := AsRemoteError(err); !ok && err != nil {
 						logger.Error(err)
 					}
-					v.MsgReply <- respMsg
+					v.reply <- &rabbitReply{msg: respMsg, err: err}
 					delete(r.WaitMap, reply.CorrelationId)
 				} else {
 					logger.Errorf("WaitReply can't find reply msg,queue:%v,corrid:%v", r.QueueName, reply.CorrelationId)
@@ -133,29 +240,69 @@ func (r *RabbitMqRpc) GetReplyQueue(subReply string) (*RabbitReplyQueue, error)
 		return nil, err
 	}
 	queue := NewRabbitReplyQueue(subReply, msgs, r.RpcCoder)
+	queue.conn = conn
+	queue.onClose = func() {
+		r.ReplyQueues.CompareAndDelete(subReply, queue)
+		if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			logger.Error(err)
+		}
+	}
 	queue.WaitReply()
 	r.ReplyQueues.Store(subReply, queue)
 	return queue, nil
 }
 
 type RabbitMqRpc struct {
-	Endpoints     []string //地址取第一条
-	DebugMsg      bool
-	Prefix        string
-	RpcCoder      map[string]EncoderRpc
-	Server        *treaty.Server
-	Finder        *discover.Finder
-	Client        *amqp.Connection
-	DialTimeout   time.Duration
-	ReplyQueues   sync.Map
-	ChanPool      *pool.Pool[*amqp.Channel]
-	connLock      sync.Mutex
-	blockNotifier []chan amqp.Blocking
-	closeNotifier []chan *amqp.Error
-	defBlocker    chan amqp.Blocking
-	defCloser     chan *amqp.Error
-	blockState    bool
-	blockLock     sync.RWMutex
+	ClientChain
+	deadLetterBox
+	Endpoints         []string //地址取第一条
+	DebugMsg          bool
+	Prefix            string
+	RpcCoder          map[string]EncoderRpc
+	CompressThreshold int     //消息体超过该字节数时压缩
+	Signer            *Signer //非空时签名及校验rpc帧
+	Server            *treaty.Server
+	Finder            *discover.Finder
+	Client            *amqp.Connection
+	DialTimeout       time.Duration
+	ReplyQueues       sync.Map
+	ChanPool          *pool.Pool[*RabbitChannel]
+	ReconnectMin      time.Duration //重连退避初始间隔
+	ReconnectMax      time.Duration //重连退避最大间隔
+	StreamBuffer      int           //流式响应缓冲分片数,共享回复队列不能阻塞,缓冲满时流失败
+	connLock          sync.Mutex
+	connected         atomic.Bool
+	closing           atomic.Bool
+	consumers         []*rabbitConsumer //断线重连后重新注册
+	subLock           sync.Mutex
+	blockNotifier     []chan amqp.Blocking
+	closeNotifier     []chan *amqp.Error
+	blockState        bool
+	blockLock         sync.RWMutex
+}
+
+// RabbitChannel 池化的通道,记录所属的通道池,重连后的旧通道及已关闭的通道不再放回池中
+type RabbitChannel struct {
+	*amqp.Channel
+	pool   *pool.Pool[*RabbitChannel]
+	closes chan *amqp.Error
+}
+
+// closed 通道已被服务端关闭或随连接断开
+func (c *RabbitChannel) closed() bool {
+	select {
+	case <-c.closes:
+		return true
+	default:
+		return false
+	}
+}
+
+// rabbitConsumer 已注册的订阅
+type rabbitConsumer struct {
+	sub   string
+	s     RssBuilder
+	coder EncoderRpc
 }
 
 type RabbitMqRpcOption func(r *RabbitMqRpc)
@@ -175,6 +322,16 @@ func WithRabbitMqDialTimeout(timeout time.Duration) RabbitMqRpcOption {
 		r.DialTimeout = timeout
 	}
 }
+func WithRabbitMqCompressThreshold(threshold int) RabbitMqRpcOption {
+	return func(r *RabbitMqRpc) {
+		r.CompressThreshold = threshold
+	}
+}
+func WithRabbitMqSigner(signer *Signer) RabbitMqRpcOption {
+	return func(r *RabbitMqRpc) {
+		r.Signer = signer
+	}
+}
 func WithRabbitMqServer(server *treaty.Server) RabbitMqRpcOption {
 	return func(r *RabbitMqRpc) {
 		r.Server = server
@@ -192,23 +349,42 @@ func WithRabbitCloseNotifier(notifier chan *amqp.Error) RabbitMqRpcOption {
 	}
 }
 
+// WithRabbitMqReconnectBackoff 连接断开后按指数退避重连,间隔从min翻倍至max
+func WithRabbitMqReconnectBackoff(min, max time.Duration) RabbitMqRpcOption {
+	return func(r *RabbitMqRpc) {
+		r.ReconnectMin = min
+		r.ReconnectMax = max
+	}
+}
+
+// WithRabbitMqStreamBuffer 流式响应缓冲的分片数,读取慢于发送且超过该数量时流以ErrStreamOverflow失败
+func WithRabbitMqStreamBuffer(buffer int) RabbitMqRpcOption {
+	return func(r *RabbitMqRpc) {
+		r.StreamBuffer = buffer
+	}
+}
+
 func WithRabbitMqPrefix(prefix string) RabbitMqRpcOption {
 	return func(r *RabbitMqRpc) {
 		r.Prefix = prefix
 	}
 }
 
+func WithRabbitMqClientInterceptors(interceptors ...ClientInterceptor) RabbitMqRpcOption {
+	return func(r *RabbitMqRpc) {
+		r.UseClientInterceptors(interceptors...)
+	}
+}
+
 func NewRpcRabbitMq(opts ...RabbitMqRpcOption) *RabbitMqRpc {
 	r := &RabbitMqRpc{
-		Prefix:      "rmRpc",
-		ReplyQueues: sync.Map{},
-		defBlocker:  make(chan amqp.Blocking, 10),
-		defCloser:   make(chan *amqp.Error, 10),
-		blockState:  false,
-		blockLock:   sync.RWMutex{},
-	}
-	r.blockNotifier = append(r.blockNotifier, r.defBlocker)
-	r.closeNotifier = append(r.closeNotifier, r.defCloser)
+		Prefix:       "rmRpc",
+		ReplyQueues:  sync.Map{},
+		ReconnectMin: DefaultRabbitReconnectMin,
+		ReconnectMax: DefaultRabbitReconnectMax,
+		blockState:   false,
+		blockLock:    sync.RWMutex{},
+	}
 	for _, opt := range opts {
 		opt(r)
 	}
@@ -219,14 +395,8 @@ func NewRpcRabbitMq(opts ...RabbitMqRpcOption) *RabbitMqRpc {
 	if err != nil {
 		logger.Fatal(err)
 	}
-	r.RpcCoder = map[string]EncoderRpc{
-		CodeTypeProto: NewRpcEncoder(serialize.NewProtoSerializer()),
-		CodeTypeJson:  NewRpcEncoder(serialize.NewJsonSerializer()),
-	}
+	r.RpcCoder = newRpcCoders(WithCompressThreshold(r.CompressThreshold), WithSigner(r.Signer))
 	r.Finder = discover.NewFinder()
-	//阻塞及关闭处理
-	go r.dealBlocked()
-	go r.dealClosed()
 	return r
 }
 
@@ -241,40 +411,92 @@ func (r *RabbitMqRpc) getBlockState() bool {
 	return r.blockState
 }
 
-func (r *RabbitMqRpc) dealBlocked() {
-	for blocker := range r.defBlocker {
-		logger.Warnf("dealBlocked:%+v", blocker)
-		if blocker.Active {
-			r.setBlockState(true)
-		} else {
+// watch 处理连接的阻塞及关闭通知并转发给外部通知通道,异常关闭时重连
+// amqp在连接关闭后会关闭通知通道,因此每个连接使用新的通道,外部通道不直接注册
+func (r *RabbitMqRpc) watch(blocks <-chan amqp.Blocking, closes <-chan *amqp.Error) {
+	for {
+		select {
+		case blocker, ok := <-blocks:
+			if !ok {
+				blocks = nil
+				continue
+			}
+			logger.Warnf("dealBlocked:%+v", blocker)
+			r.setBlockState(blocker.Active)
+			for _, notifier := range r.blockNotifier {
+				select {
+				case notifier <- blocker:
+				default:
+				}
+			}
+		case closer, ok := <-closes:
+			//主动关闭时通道直接关闭,无需重连
+			if !ok {
+				return
+			}
+			logger.Warnf("dealClosed:%+v", closer)
+			for _, notifier := range r.closeNotifier {
+				select {
+				case notifier <- closer:
+				default:
+				}
+			}
 			r.setBlockState(false)
+			r.reconnect()
+			return
 		}
 	}
 }
-func (r *RabbitMqRpc) dealClosed() {
-	for closer := range r.defCloser {
-		logger.Warnf("dealClosed:%+v", closer)
+
+// reconnect 按指数退避重连,连接成功后重新声明队列并注册所有订阅
+func (r *RabbitMqRpc) reconnect() {
+	r.connected.Store(false)
+	backoff := r.ReconnectMin
+	for attempt := 1; ; attempt++ {
+		time.Sleep(backoff)
+		if r.closing.Load() {
+			return
+		}
+		err := r.connect()
+		if err == nil {
+			err = r.resubscribe()
+		}
+		if err == nil {
+			logger.Infof("rabbitmq reconnected, attempt:%v", attempt)
+			return
+		}
+		if backoff *= 2; backoff > r.ReconnectMax {
+			backoff = r.ReconnectMax
+		}
+		logger.Errorf("rabbitmq reconnect failed, attempt:%v, retry after:%v, err:%v", attempt, backoff, err)
 	}
 }
 
+// conn 当前连接,重连期间为已关闭的旧连接
+func (r *RabbitMqRpc) conn() *amqp.Connection {
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	return r.Client
+}
+
 func (r *RabbitMqRpc) openConn() (*amqp.Connection, error) {
 	return amqp.Dial(r.Endpoints[0])
 }
 
+// AddBlockedNotifier 添加阻塞通知,通知非阻塞发送,通道需有缓冲
 func (r *RabbitMqRpc) AddBlockedNotifier(notifier chan amqp.Blocking) {
 	if notifier == nil {
 		return
 	}
 	r.blockNotifier = append(r.blockNotifier, notifier)
-	r.Client.NotifyBlocked(notifier)
 }
 
+// AddCloseNotifier 添加连接异常关闭通知,重连后无需重新添加,通道需有缓冲
 func (r *RabbitMqRpc) AddCloseNotifier(notifier chan *amqp.Error) {
 	if notifier == nil {
 		return
 	}
 	r.closeNotifier = append(r.closeNotifier, notifier)
-	r.Client.NotifyClose(notifier)
 }
 
 func (r *RabbitMqRpc) RegEncoder(typ string, encoder EncoderRpc) {
@@ -290,20 +512,38 @@ func (r *RabbitMqRpc) DealMsg(s RssBuilder, ch *amqp.Channel, msg amqp.Delivery,
 	err := coder.Decode(msg.Body, req)
 	if err != nil {
 		logger.Error(err)
+		r.putDeadLetter("rabbitmq", msg.Exchange, msg.RoutingKey, msg.Body, DeadReasonDecode, err.Error(), nil)
 		return
 	}
-	resp := callback(req)
+	defer r.guardDeadLetter("rabbitmq", msg.Exchange, msg.RoutingKey, msg.Body)
+	ctx, cancel := msgContext(msg.Headers[HeaderTimeout])
+	defer cancel()
+	var replyCh *amqp.Channel
+	defer func() {
+		if replyCh != nil {
+			replyCh.Close()
+		}
+	}()
+	dialTimeout := r.dialTimeoutRss(s)
+	//回复使用同一通道,保证流式分片有序
+	reply := func(data []byte) error {
+		if replyCh == nil {
+			c, err := r.conn().Channel()
+			if err != nil {
+				return err
+			}
+			replyCh = c
+		}
+		return r.publishReply(replyCh, msg.CorrelationId, msg.ReplyTo, dialTimeout, data)
+	}
+	if req.MsgType == MsgTypeStream && len(msg.ReplyTo) > 0 {
+		req.reply = reply
+	}
+	resp := callback(req.WithContext(ctx))
 	if resp != nil {
-		replyCh, err := r.Client.Channel()
+		err = reply(resp)
 		if err != nil {
 			logger.Errorf("DealMsg 回复报错:subReply:%v,corrid:%v,err:%v", msg.ReplyTo, msg.CorrelationId, err)
-			return
-		}
-		defer replyCh.Close()
-		dialTimeout := r.dialTimeoutRss(s)
-		err = r.publishReply(replyCh, msg.CorrelationId, msg.ReplyTo, dialTimeout, resp)
-		if err != nil {
-			logger.Error(err)
 		} else if r.DebugMsg {
 			logger.Infof("DealMsg 回复消息:subReply:%v,corrid:%v", msg.ReplyTo, msg.CorrelationId)
 		}
@@ -345,86 +585,73 @@ func (r *RabbitMqRpc) publishReply(ch *amqp.Channel, corrId, replyTo string, tim
 	}
 }
 func (r *RabbitMqRpc) Subscribe(s RssBuilder) error {
-	ch, err := r.getChannel()
-	if err != nil {
-		return err
-	}
-	defer r.releaseChannel(ch)
 	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
-	if err != nil {
-		return err
-	}
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	msgs, err := ch.Consume(sub, "", true, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-	go utils.SafeRun(func() {
-		for msg := range msgs {
-			utils.SafeRun(func() {
-				r.DealMsg(s, ch, msg, s.callback, coder)
-			})
-		}
-	})
-	return nil
+	return r.subscribe(s, sub)
 }
 
 func (r *RabbitMqRpc) SubscribeBroadcast(s RssBuilder) error {
-	ch, err := r.getChannel()
-	if err != nil {
-		return err
-	}
-	defer r.releaseChannel(ch)
 	sub := path.Join(r.Prefix, s.server.ServerType, s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
-	if err != nil {
-		return err
-	}
+	return r.subscribe(s, sub)
+}
+
+func (r *RabbitMqRpc) QueueSubscribe(s RssBuilder) error {
+	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.server.ServerType, s.queue), s.suffix)
+	return r.subscribe(s, sub)
+}
+
+// subscribe 注册订阅并记录,重连后重新注册
+func (r *RabbitMqRpc) subscribe(s RssBuilder, sub string) error {
 	coder := r.RpcCoder[s.codeType]
 	if coder == nil {
 		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
 	}
-	msgs, err := ch.Consume(sub, "", true, false, false, false, nil)
-	if err != nil {
+	c := &rabbitConsumer{sub: sub, s: s, coder: coder}
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	if err := r.consume(c); err != nil {
 		return err
 	}
-	go utils.SafeRun(func() {
-		for msg := range msgs {
-			utils.SafeRun(func() {
-				r.DealMsg(s, ch, msg, s.callback, coder)
-			})
+	r.consumers = append(r.consumers, c)
+	//发送时路由键与队列名相同,未指定交换机的消息经默认交换机投递
+	r.addDeadLetterRoute("", sub, s, coder)
+	if len(s.exName) > 0 {
+		r.addDeadLetterRoute(s.exName, sub, s, coder)
+	}
+	return nil
+}
+
+// resubscribe 重新注册所有订阅
+func (r *RabbitMqRpc) resubscribe() error {
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	for _, c := range r.consumers {
+		if err := r.consume(c); err != nil {
+			return fmt.Errorf("resubscribe failed, sub:%v, err:%w", c.sub, err)
 		}
-	})
+	}
 	return nil
 }
 
-func (r *RabbitMqRpc) QueueSubscribe(s RssBuilder) error {
-	ch, err := r.getChannel()
+// consume 声明队列并在独占通道上消费,连接断开时消费协程随通道关闭退出
+func (r *RabbitMqRpc) consume(c *rabbitConsumer) error {
+	ch, err := r.conn().Channel()
 	if err != nil {
 		return err
 	}
-	defer r.releaseChannel(ch)
-	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.server.ServerType, s.queue), s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
+	err = r.prepareMq(ch, c.s.exName, c.s.exType, c.sub, c.sub)
 	if err != nil {
+		_ = ch.Close()
 		return err
 	}
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	msgs, err := ch.Consume(sub, "", true, false, false, false, nil)
+	msgs, err := ch.Consume(c.sub, "", true, false, false, false, nil)
 	if err != nil {
+		_ = ch.Close()
 		return err
 	}
 	go utils.SafeRun(func() {
 		for msg := range msgs {
 			utils.SafeRun(func() {
-				r.DealMsg(s, ch, msg, s.callback, coder)
+				r.DealMsg(c.s, ch, msg, c.s.callback, c.coder)
 			})
 		}
 	})
@@ -493,93 +720,114 @@ func (r *RabbitMqRpc) dialTimeoutRss(s RssBuilder) time.Duration {
 	return r.DialTimeout
 }
 
-func (r *RabbitMqRpc) getChannel() (*amqp.Channel, error) {
+func (r *RabbitMqRpc) getChannel() (*RabbitChannel, error) {
+	if !r.connected.Load() {
+		return nil, ErrRabbitDisconnected
+	}
 	if r.getBlockState() {
 		return nil, ErrorBlocked
 	}
-	ch, err := r.ChanPool.Acquire()
-	if err != nil {
-		logger.Error(err)
-		err := r.connect()
+	r.connLock.Lock()
+	chanPool := r.ChanPool
+	r.connLock.Unlock()
+	if chanPool == nil {
+		return nil, ErrRabbitDisconnected
+	}
+	for {
+		ch, err := chanPool.Acquire()
 		if err != nil {
 			return nil, err
 		}
-		ch, err = r.ChanPool.Acquire()
-		if err != nil {
-			return nil, err
+		//空闲期间被关闭的通道直接丢弃
+		if !ch.closed() {
+			return ch, nil
 		}
 	}
-	return ch, nil
 }
 
 func (r *RabbitMqRpc) connect() error {
 	r.connLock.Lock()
 	defer r.connLock.Unlock()
+	//重连时旧连接已断开,关闭错误无需记录
 	if r.Client != nil {
-		if err := r.Client.Close(); err != nil {
+		if err := r.Client.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
 			logger.Error(err)
 		}
+		r.Client = nil
 	}
 	if r.ChanPool != nil {
 		r.ChanPool.Close()
+		r.ChanPool = nil
 	}
 	conn, err := r.openConn()
 	if err != nil {
 		return err
 	}
-	r.Client = conn
-	chanPool, err := pool.New(func() (*amqp.Channel, error) {
-		ch, err := r.Client.Channel()
+	var chanPool *pool.Pool[*RabbitChannel]
+	chanPool, err = pool.New(func() (*RabbitChannel, error) {
+		ch, err := conn.Channel()
 		if err != nil {
 			return nil, err
 		}
-		return ch, nil
+		return &RabbitChannel{Channel: ch, pool: chanPool, closes: ch.NotifyClose(make(chan *amqp.Error, 1))}, nil
 	}, 10)
 	if err != nil {
+		_ = conn.Close()
 		return err
 	}
+	r.Client = conn
 	r.ChanPool = chanPool
-	for _, notifier := range r.blockNotifier {
-		r.Client.NotifyBlocked(notifier)
-	}
-	for _, notifier := range r.closeNotifier {
-		r.Client.NotifyClose(notifier)
-	}
+	r.connected.Store(true)
+	go r.watch(conn.NotifyBlocked(make(chan amqp.Blocking, 1)), conn.NotifyClose(make(chan *amqp.Error, 1)))
 	return nil
 }
 
-func (r *RabbitMqRpc) releaseChannel(ch *amqp.Channel) {
-	r.ChanPool.Release(ch)
+// releaseChannel 归还通道,借出期间发生重连或通道已关闭时直接关闭丢弃
+func (r *RabbitMqRpc) releaseChannel(ch *RabbitChannel) {
+	r.connLock.Lock()
+	chanPool := r.ChanPool
+	r.connLock.Unlock()
+	if ch.pool != chanPool || ch.closed() {
+		_ = ch.Close()
+		return
+	}
+	chanPool.Release(ch)
 }
 
 // 发送消息
 func (r *RabbitMqRpc) SendMsg(s ReqBuilder) error {
-	ch, err := r.getChannel()
-	if err != nil {
-		return err
-	}
-	defer r.releaseChannel(ch)
-	queue := s.queue
-	rtKey := s.rtKey
-	if len(r.Prefix) > 0 {
-		queue = r.Prefix + "_" + s.queue
-	}
-	if len(r.Prefix) > 0 && len(s.rtKey) > 0 {
-		rtKey = r.Prefix + "_" + s.rtKey
-	}
-	err = r.prepareMq(ch, s.exName, s.exType, queue, rtKey)
-	if err != nil {
-		return err
-	}
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	data, err := r.EncodeMsgRaw(coder, MsgTypePublish, s.msgId, s.req)
-	if err != nil {
-		return err
-	}
-	return r.publishData(ch, queue, s.exName, rtKey, r.dialTimeout(s), data)
+	return r.SendMsgWithContext(context.Background(), s)
+}
+
+func (r *RabbitMqRpc) SendMsgWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodSendMsg, s, func(ctx context.Context, s ReqBuilder) error {
+		ch, err := r.getChannel()
+		if err != nil {
+			return err
+		}
+		defer r.releaseChannel(ch)
+		queue := s.queue
+		rtKey := s.rtKey
+		if len(r.Prefix) > 0 {
+			queue = r.Prefix + "_" + s.queue
+		}
+		if len(r.Prefix) > 0 && len(s.rtKey) > 0 {
+			rtKey = r.Prefix + "_" + s.rtKey
+		}
+		err = r.prepareMq(ch.Channel, s.exName, s.exType, queue, rtKey)
+		if err != nil {
+			return err
+		}
+		coder := r.RpcCoder[s.codeType]
+		if coder == nil {
+			return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+		}
+		data, err := r.EncodeMsgRaw(coder, MsgTypePublish, s.msgId, s.req)
+		if err != nil {
+			return err
+		}
+		return r.publishData(ctx, ch.Channel, queue, s.exName, rtKey, r.dialTimeout(s), data)
+	})
 }
 
 func (r *RabbitMqRpc) publishDataChan(ch *amqp.Channel, queue, exName, rtKey string, msg amqp.Publishing) <-chan error {
@@ -606,7 +854,10 @@ func (r *RabbitMqRpc) publishDataChan(ch *amqp.Channel, queue, exName, rtKey str
 	return err
 }
 
-func (r *RabbitMqRpc) publishData(ch *amqp.Channel, queue, exName, rtKey string, timeout time.Duration, data []byte, args ...string) error {
+func (r *RabbitMqRpc) publishData(ctx context.Context, ch *amqp.Channel, queue, exName, rtKey string, timeout time.Duration, data []byte, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	msg := amqp.Publishing{
 		ContentType:  "text/plain",
 		Body:         data,
@@ -618,26 +869,30 @@ func (r *RabbitMqRpc) publishData(ch *amqp.Channel, queue, exName, rtKey string,
 	if len(args) > 1 {
 		msg.ReplyTo = args[1]
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	if timeout, ok := timeoutNano(ctx); ok {
+		msg.Headers = amqp.Table{HeaderTimeout: timeout}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	errChan := r.publishDataChan(ch, queue, exName, rtKey, msg)
 	select {
 	case <-ctx.Done():
+		if ctx.Err() == context.Canceled {
+			return ctx.Err()
+		}
 		return ErrorTimeout
 	case err := <-errChan:
 		return err
 	}
 }
 
-// 发送消息
-func (r *RabbitMqRpc) Publish(s ReqBuilder) error {
+func (r *RabbitMqRpc) publish(ctx context.Context, s ReqBuilder, sub string) error {
 	ch, err := r.getChannel()
 	if err != nil {
 		return err
 	}
 	defer r.releaseChannel(ch)
-	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
+	err = r.prepareMq(ch.Channel, s.exName, s.exType, sub, sub)
 	if err != nil {
 		return err
 	}
@@ -645,43 +900,54 @@ func (r *RabbitMqRpc) Publish(s ReqBuilder) error {
 	if coder == nil {
 		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
 	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
+	data, err := coder.Encode(s.message(MsgTypePublish))
 	if err != nil {
 		return err
 	}
-	return r.publishData(ch, sub, s.exName, sub, r.dialTimeout(s), data)
+	return r.publishData(ctx, ch.Channel, sub, s.exName, sub, r.dialTimeout(s), data)
+}
+
+// 发送消息
+func (r *RabbitMqRpc) Publish(s ReqBuilder) error {
+	return r.PublishWithContext(context.Background(), s)
+}
+
+func (r *RabbitMqRpc) PublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodPublish, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		return r.publish(ctx, s, sub)
+	})
 }
 
 func (r *RabbitMqRpc) QueuePublish(s ReqBuilder) error {
-	ch, err := r.getChannel()
-	if err != nil {
-		return err
-	}
-	defer r.releaseChannel(ch)
-	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
-	if err != nil {
-		return err
-	}
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
-	if err != nil {
-		return err
-	}
-	return r.publishData(ch, sub, s.exName, sub, r.dialTimeout(s), data)
+	return r.QueuePublishWithContext(context.Background(), s)
+}
+
+func (r *RabbitMqRpc) QueuePublishWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodQueuePublish, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		return r.publish(ctx, s, sub)
+	})
 }
 
 func (r *RabbitMqRpc) PublishBroadcast(s ReqBuilder) error {
+	return r.PublishBroadcastWithContext(context.Background(), s)
+}
+
+func (r *RabbitMqRpc) PublishBroadcastWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodPublishBroadcast, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, s.serverType, s.suffix)
+		return r.publish(ctx, s, sub)
+	})
+}
+
+func (r *RabbitMqRpc) request(ctx context.Context, s ReqBuilder, sub string) error {
 	ch, err := r.getChannel()
 	if err != nil {
 		return err
 	}
 	defer r.releaseChannel(ch)
-	sub := path.Join(r.Prefix, s.serverType, s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
+	err = r.prepareMq(ch.Channel, s.exName, s.exType, sub, sub)
 	if err != nil {
 		return err
 	}
@@ -689,21 +955,7 @@ func (r *RabbitMqRpc) PublishBroadcast(s ReqBuilder) error {
 	if coder == nil {
 		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
 	}
-	data, err := r.EncodeMsg(coder, MsgTypePublish, s.msgId, s.req)
-	if err != nil {
-		return err
-	}
-	return r.publishData(ch, sub, s.exName, sub, r.dialTimeout(s), data)
-}
-
-func (r *RabbitMqRpc) Request(s ReqBuilder) error {
-	ch, err := r.getChannel()
-	if err != nil {
-		return err
-	}
-	defer r.releaseChannel(ch)
-	sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
+	data, err := coder.Encode(s.message(MsgTypeRequest))
 	if err != nil {
 		return err
 	}
@@ -717,91 +969,128 @@ func (r *RabbitMqRpc) Request(s ReqBuilder) error {
 		CorrId:   corrId,
 		CodeType: s.codeType,
 		MsgData:  s.resp,
-		MsgReply: make(chan *MsgRpc, 1),
+		reply:    make(chan *rabbitReply, 1),
 	}
-	replyQueue.WaitChan <- replyItem
+	if err = replyQueue.wait(replyItem); err != nil {
+		return err
+	}
+	//发送失败或超时未收到回复时移除等待项
+	replied := false
+	defer func() {
+		if !replied {
+			replyQueue.cancel(corrId)
+		}
+	}()
 	if r.DebugMsg {
 		logger.Infof("Request 发送消息:subReply:%v,corrid:%v", subReply, corrId)
 	}
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
-	}
-	data, err := r.EncodeMsg(coder, MsgTypeRequest, s.msgId, s.req)
-	if err != nil {
-		return err
-	}
 	dialTimeout := r.dialTimeout(s)
-	err = r.publishData(ch, sub, s.exName, sub, dialTimeout, data, corrId, subReply)
+	replyCtx, replyCancel := withDialTimeout(ctx, 2*dialTimeout)
+	defer replyCancel()
+	err = r.publishData(replyCtx, ch.Channel, sub, s.exName, sub, dialTimeout, data, corrId, subReply)
 	if err != nil {
 		return err
 	}
-	replyCtx, replyCancel := context.WithTimeout(context.Background(), 2*dialTimeout)
-	defer replyCancel()
 	for {
 		select {
-		case item := <-replyItem.MsgReply:
-			s.resp = item
+		case item := <-replyItem.reply:
+			replied = true
 			if r.DebugMsg {
 				logger.Infof("Request 收到消息:subReply:%v,corrid:%v", subReply, corrId)
 			}
-			return nil
+			return item.err
 		case <-replyCtx.Done():
-			return fmt.Errorf("消息返回超时,subReply:%v,corrId:%v", subReply, corrId)
+			return fmt.Errorf("消息返回超时,subReply:%v,corrId:%v,err:%w", subReply, corrId, replyCtx.Err())
 		}
 	}
 }
 
-func (r *RabbitMqRpc) QueueRequest(s ReqBuilder) error {
+func (r *RabbitMqRpc) requestStream(ctx context.Context, s ReqBuilder, sub string) (*StreamReader, error) {
 	ch, err := r.getChannel()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer r.releaseChannel(ch)
-	sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
-	err = r.prepareMq(ch, s.exName, s.exType, sub, sub)
+	err = r.prepareMq(ch.Channel, s.exName, s.exType, sub, sub)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	coder := r.RpcCoder[s.codeType]
+	if coder == nil {
+		return nil, fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	}
+	data, err := coder.Encode(s.message(MsgTypeStream))
+	if err != nil {
+		return nil, err
 	}
 	corrId := uuid.NewString()
 	subReply := path.Join(sub, DefaultReply)
 	replyQueue, err := r.GetReplyQueue(subReply)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	replyItem := &RabbitWaitItem{
+	dialTimeout := r.dialTimeout(s)
+	reader := newStreamReader(ctx, coder, dialTimeout, r.StreamBuffer)
+	reader.closer = func() {
+		replyQueue.cancel(corrId)
+	}
+	if err = replyQueue.wait(&RabbitWaitItem{
 		CorrId:   corrId,
 		CodeType: s.codeType,
-		MsgData:  s.resp,
-		MsgReply: make(chan *MsgRpc, 1),
+		Stream:   reader,
+	}); err != nil {
+		reader.Close()
+		return nil, err
 	}
-	replyQueue.WaitChan <- replyItem
-	logger.Warnf("QueueRequest 发送消息:subReply:%v,corrid:%v", subReply, corrId)
-	coder := r.RpcCoder[s.codeType]
-	if coder == nil {
-		return fmt.Errorf("rpc coder not exist:%v", s.codeType)
+	if r.DebugMsg {
+		logger.Infof("RequestStream 发送消息:subReply:%v,corrid:%v", subReply, corrId)
 	}
-	data, err := r.EncodeMsg(coder, MsgTypeRequest, s.msgId, s.req)
+	err = r.publishData(ctx, ch.Channel, sub, s.exName, sub, dialTimeout, data, corrId, subReply)
 	if err != nil {
-		return err
+		reader.Close()
+		return nil, err
 	}
-	dialTimeout := r.dialTimeout(s)
-	err = r.publishData(ch, sub, s.exName, sub, dialTimeout, data, corrId, subReply)
-	if err != nil {
+	return reader, nil
+}
+
+func (r *RabbitMqRpc) RequestStream(ctx context.Context, s ReqBuilder) (reader *StreamReader, err error) {
+	err = r.intercept(ctx, MethodRequestStream, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		reader, err = r.requestStream(ctx, s, sub)
 		return err
-	}
-	replyCtx, replyCancel := context.WithTimeout(context.TODO(), 2*dialTimeout)
-	defer replyCancel()
-	for {
-		select {
-		case item := <-replyItem.MsgReply:
-			s.resp = item
-			logger.Warnf("QueueRequest 收到消息:subReply:%v,corrid:%v", subReply, corrId)
-			return nil
-		case <-replyCtx.Done():
-			return fmt.Errorf("消息返回超时,subReply:%v,corrId:%v", subReply, corrId)
-		}
-	}
+	})
+	return
+}
+
+func (r *RabbitMqRpc) QueueRequestStream(ctx context.Context, s ReqBuilder) (reader *StreamReader, err error) {
+	err = r.intercept(ctx, MethodQueueRequestStream, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		reader, err = r.requestStream(ctx, s, sub)
+		return err
+	})
+	return
+}
+
+func (r *RabbitMqRpc) Request(s ReqBuilder) error {
+	return r.RequestWithContext(context.Background(), s)
+}
+
+func (r *RabbitMqRpc) RequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodRequest, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverItem(s.server), s.suffix)
+		return r.request(ctx, s, sub)
+	})
+}
+
+func (r *RabbitMqRpc) QueueRequest(s ReqBuilder) error {
+	return r.QueueRequestWithContext(context.Background(), s)
+}
+
+func (r *RabbitMqRpc) QueueRequestWithContext(ctx context.Context, s ReqBuilder) error {
+	return r.intercept(ctx, MethodQueueRequest, s, func(ctx context.Context, s ReqBuilder) error {
+		sub := path.Join(r.Prefix, treaty.RegSeverQueue(s.serverType, s.queue), s.suffix)
+		return r.request(ctx, s, sub)
+	})
 }
 
 func (r *RabbitMqRpc) Response(codeType string, v any) []byte {
@@ -813,6 +1102,15 @@ func (r *RabbitMqRpc) Response(codeType string, v any) []byte {
 	return coder.Response(v)
 }
 
+func (r *RabbitMqRpc) ResponseError(codeType string, err error) []byte {
+	coder := r.RpcCoder[codeType]
+	if coder == nil {
+		logger.Errorf("rpc coder not exist:%v", codeType)
+		return nil
+	}
+	return responseError(coder, err)
+}
+
 func (r *RabbitMqRpc) DecodeMsg(codeType string, data []byte, v any) error {
 	coder := r.RpcCoder[codeType]
 	if coder == nil {
@@ -837,7 +1135,30 @@ func (r *RabbitMqRpc) RemoveFindCache(arg any) {
 	r.Finder.RemoveUserCache(arg)
 }
 
+func (r *RabbitMqRpc) GetFinder() *discover.Finder {
+	return r.Finder
+}
+
+func (r *RabbitMqRpc) GetSigner() *Signer {
+	return r.Signer
+}
+
 func (r *RabbitMqRpc) Close() error {
-	r.ChanPool.Close()
+	r.closing.Store(true)
+	r.ReplyQueues.Range(func(key, value any) bool {
+		if conn := value.(*RabbitReplyQueue).conn; conn != nil {
+			_ = conn.Close()
+		}
+		return true
+	})
+	r.connLock.Lock()
+	defer r.connLock.Unlock()
+	r.connected.Store(false)
+	if r.ChanPool != nil {
+		r.ChanPool.Close()
+	}
+	if r.Client == nil {
+		return nil
+	}
 	return r.Client.Close()
 }
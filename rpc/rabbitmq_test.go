@@ -0,0 +1,259 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitReplyQueueDisconnect(t *testing.T) {
+	deliveries := make(chan amqp.Delivery)
+	queue := NewRabbitReplyQueue("reply", deliveries, newRpcCoders())
+	closed := make(chan struct{})
+	queue.onClose = func() { close(closed) }
+	go queue.WaitReply()
+
+	reply := &RabbitWaitItem{CorrId: "1", CodeType: CodeTypeJson, reply: make(chan *rabbitReply, 1)}
+	stream := newStreamReader(context.Background(), queue.RpcCoder[CodeTypeJson], 0, 0)
+	for _, item := range []*RabbitWaitItem{reply, {CorrId: "2", CodeType: CodeTypeJson, Stream: stream}} {
+		if err := queue.wait(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(deliveries)
+
+	select {
+	case res := <-reply.reply:
+		if !errors.Is(res.err, ErrRabbitDisconnected) {
+			t.Fatalf("unexpected reply err:%v", res.err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending request not failed")
+	}
+	if err := stream.Recv(&struct{}{}); !errors.Is(err, ErrRabbitDisconnected) {
+		t.Fatalf("unexpected stream err:%v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("onClose not called")
+	}
+	if err := queue.wait(&RabbitWaitItem{CorrId: "3"}); !errors.Is(err, ErrRabbitDisconnected) {
+		t.Fatalf("unexpected wait err:%v", err)
+	}
+}
+
+func TestRabbitReplyQueueCancel(t *testing.T) {
+	deliveries := make(chan amqp.Delivery)
+	queue := NewRabbitReplyQueue("reply", deliveries, newRpcCoders())
+	closed := make(chan struct{})
+	queue.onClose = func() { close(closed) }
+	queue.WaitReply()
+	//登记后立即移除,不能因处理顺序残留
+	items := make([]*RabbitWaitItem, 100)
+	for i := range items {
+		items[i] = &RabbitWaitItem{CorrId: strconv.Itoa(i), reply: make(chan *rabbitReply, 1)}
+		if err := queue.wait(items[i]); err != nil {
+			t.Fatal(err)
+		}
+		queue.cancel(items[i].CorrId)
+	}
+	time.Sleep(10 * time.Millisecond)
+	//断开时残留的等待项会收到错误
+	close(deliveries)
+	<-closed
+	for _, item := range items {
+		select {
+		case <-item.reply:
+			t.Fatalf("wait item leaked:%v", item.CorrId)
+		default:
+		}
+	}
+}
+
+// fakeAmqpServer 只应答连接及通道的打开和关闭,用于测试通道池
+type fakeAmqpServer struct {
+	ln    net.Listener
+	lock  sync.Mutex
+	conns []*fakeAmqpConn
+}
+
+type fakeAmqpConn struct {
+	conn   net.Conn
+	lock   sync.Mutex
+	opened uint16 //最近打开的通道
+}
+
+func newFakeAmqpServer(t *testing.T) *fakeAmqpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeAmqpServer{ln: ln}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := &fakeAmqpConn{conn: conn}
+			srv.lock.Lock()
+			srv.conns = append(srv.conns, c)
+			srv.lock.Unlock()
+			go c.serve()
+		}
+	}()
+	return srv
+}
+
+func (s *fakeAmqpServer) url() string {
+	return "amqp://guest:guest@" + s.ln.Addr().String() + "/"
+}
+
+// last 最近建立的连接
+func (s *fakeAmqpServer) last() *fakeAmqpConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conns[len(s.conns)-1]
+}
+
+func (c *fakeAmqpConn) method(channel uint16, class, method uint16, args ...any) {
+	payload := &bytes.Buffer{}
+	_ = binary.Write(payload, binary.BigEndian, [2]uint16{class, method})
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string: //shortstr
+			payload.WriteByte(byte(len(v)))
+			payload.WriteString(v)
+		case []byte: //longstr
+			_ = binary.Write(payload, binary.BigEndian, uint32(len(v)))
+			payload.Write(v)
+		default:
+			_ = binary.Write(payload, binary.BigEndian, v)
+		}
+	}
+	frame := &bytes.Buffer{}
+	frame.WriteByte(1)
+	_ = binary.Write(frame, binary.BigEndian, channel)
+	_ = binary.Write(frame, binary.BigEndian, uint32(payload.Len()))
+	frame.Write(payload.Bytes())
+	frame.WriteByte(0xCE)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, _ = c.conn.Write(frame.Bytes())
+}
+
+// closeOpened 服务端关闭最近打开的通道
+func (c *fakeAmqpConn) closeOpened() {
+	c.lock.Lock()
+	channel := c.opened
+	c.lock.Unlock()
+	c.method(channel, 20, 40, uint16(406), "PRECONDITION_FAILED", uint16(0), uint16(0))
+}
+
+func (c *fakeAmqpConn) serve() {
+	defer c.conn.Close()
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return
+	}
+	c.method(0, 10, 10, uint8(0), uint8(9), uint32(0), []byte("PLAIN"), []byte("en_US"))
+	for {
+		head := make([]byte, 7)
+		if _, err := io.ReadFull(c.conn, head); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(head[3:])+1)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			return
+		}
+		if head[0] != 1 {
+			continue
+		}
+		channel := binary.BigEndian.Uint16(head[1:])
+		switch [2]uint16{binary.BigEndian.Uint16(body), binary.BigEndian.Uint16(body[2:])} {
+		case [2]uint16{10, 11}: //connection.start-ok
+			c.method(0, 10, 30, uint16(0), uint32(131072), uint16(0))
+		case [2]uint16{10, 40}: //connection.open
+			c.method(0, 10, 41, "")
+		case [2]uint16{10, 50}: //connection.close,等待客户端断开,避免先断开被当作异常关闭
+			c.method(0, 10, 51)
+		case [2]uint16{20, 10}: //channel.open
+			c.lock.Lock()
+			c.opened = channel
+			c.lock.Unlock()
+			c.method(channel, 20, 11, []byte{})
+		case [2]uint16{20, 40}: //channel.close
+			c.method(channel, 20, 41)
+		}
+	}
+}
+
+func TestRabbitChannelRelease(t *testing.T) {
+	srv := newFakeAmqpServer(t)
+	r := &RabbitMqRpc{Endpoints: []string{srv.url()}}
+	if err := r.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	//借出期间重连,归还时关闭而不放入新池
+	stale, err := r.getChannel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.connect(); err != nil {
+		t.Fatal(err)
+	}
+	r.releaseChannel(stale)
+	if !stale.closed() {
+		t.Fatal("stale channel should be closed")
+	}
+	ch, err := r.ChanPool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch == stale || ch.pool != r.ChanPool {
+		t.Fatal("stale channel should not be put into the new pool")
+	}
+	//服务端关闭的通道归还时丢弃
+	srv.last().closeOpened()
+	select {
+	case <-ch.closes:
+	case <-time.After(time.Second):
+		t.Fatal("channel close not notified")
+	}
+	r.releaseChannel(ch)
+	next, err := r.ChanPool.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == ch {
+		t.Fatal("closed channel should be dropped")
+	}
+	r.releaseChannel(next)
+	if again, err := r.ChanPool.Acquire(); err != nil || again != next {
+		t.Fatalf("open channel should be reused, err:%v", err)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisMsgEnvelope(t *testing.T) {
+	msg := &redisMsg{reply: "rdRpc/_INBOX/1", corrId: "abc", timeout: int64(time.Second), frame: []byte("frame")}
+	data, err := msg.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &redisMsg{}
+	if err = res.decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if res.reply != msg.reply || res.corrId != msg.corrId || res.timeout != msg.timeout || !bytes.Equal(res.frame, msg.frame) {
+		t.Fatalf("envelope not match:%+v", res)
+	}
+	for i := 0; i < len(data)-len(msg.frame); i++ {
+		if err = res.decode(data[:i]); err == nil {
+			t.Fatalf("truncated envelope accepted, length:%v", i)
+		}
+	}
+}
+
+// newRedisTestServer 创建订阅自身、队列及广播消息的redis服务,msgId 3的处理超过调用超时
+func newRedisTestServer(t *testing.T, addr string, server *treaty.Server, broadcast *int32) *RedisRpc {
+	r := NewRpcRedis(WithRedisEndpoints([]string{addr}), WithRedisServer(server), WithRedisDialTimeout(time.Second))
+	h := NewHandler()
+	h.Register(1, func(req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+		if req.Uid == 0 {
+			return nil, NewRemoteError(1001, "uid empty")
+		}
+		return &treaty.LoginResponse{Msg: server.ServerId}, nil
+	})
+	h.Register(2, func(req *treaty.LoginRequest) {
+		atomic.AddInt32(broadcast, 1)
+	})
+	h.Register(3, func(req *treaty.LoginRequest) *treaty.LoginResponse {
+		time.Sleep(300 * time.Millisecond)
+		return &treaty.LoginResponse{}
+	})
+	callback := func(req *MsgRpc) []byte {
+		resp, err := h.DealMsg(CodeTypeJson, r, req)
+		if err != nil {
+			return r.ResponseError(CodeTypeJson, err)
+		}
+		return resp
+	}
+	b := NewRssBuilder(server).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetCallback(callback)
+	if err := r.Subscribe(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.QueueSubscribe(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SubscribeBroadcast(b.Build()); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRedisRpc(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var broadcast int32
+	s1 := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	s2 := &treaty.Server{ServerId: "1002", ServerType: "backend"}
+	r1 := newRedisTestServer(t, mr.Addr(), s1, &broadcast)
+	defer r1.Close()
+	r2 := newRedisTestServer(t, mr.Addr(), s2, &broadcast)
+	defer r2.Close()
+	client := NewRpcRedis(WithRedisEndpoints([]string{mr.Addr()}), WithRedisDialTimeout(time.Second))
+	defer client.Close()
+	ctx := context.Background()
+	//指定服务器请求
+	resp, err := CallWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, s2, 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+	if err != nil || resp.Msg != s2.ServerId {
+		t.Fatalf("request failed, err:%v, resp:%+v", err, resp)
+	}
+	//远程错误
+	_, err = CallWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, s1, 1, &treaty.LoginRequest{}, WithCallJson())
+	if re, ok := AsRemoteError(err); !ok || re.Code != 1001 {
+		t.Fatalf("want remote error, got:%v", err)
+	}
+	//队列请求每条只被一个订阅者处理
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		resp, err = CallQueueWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, "backend", 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[resp.Msg]++
+	}
+	if seen[s1.ServerId]+seen[s2.ServerId] != 6 {
+		t.Fatalf("queue request not match:%v", seen)
+	}
+	//广播
+	req := DefaultReqBuilder().SetServerType("backend").SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetMsgId(2).SetReq(&treaty.LoginRequest{}).Build()
+	if err = client.PublishBroadcast(req); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&broadcast) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&broadcast); n != 2 {
+		t.Fatalf("broadcast count not match:%v", n)
+	}
+	//超时
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = CallWith[treaty.LoginRequest, treaty.LoginResponse](tctx, client, s1, 3, &treaty.LoginRequest{}, WithCallJson())
+	if err == nil || tctx.Err() == nil {
+		t.Fatalf("want timeout, got:%v", err)
+	}
+}
+
+func TestRedisRpcQueueTTL(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := NewRpcRedis(WithRedisEndpoints([]string{mr.Addr()}), WithRedisQueueTTL(time.Minute))
+	defer client.Close()
+	req := DefaultReqBuilder().SetServerType("backend").SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetMsgId(2).SetReq(&treaty.LoginRequest{}).Build()
+	if err := client.QueuePublish(req); err != nil {
+		t.Fatal(err)
+	}
+	sub := path.Join(client.Prefix, treaty.RegSeverQueue("backend", DefaultQueue), JsonSuffix)
+	if ttl := mr.TTL(sub); ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("queue without consumer should expire, ttl:%v", ttl)
+	}
+	mr.FastForward(time.Minute)
+	if mr.Exists(sub) {
+		t.Fatal("queue not expired")
+	}
+}
+
+func TestRedisRpcOptionsCopied(t *testing.T) {
+	mr := miniredis.RunT(t)
+	options := &redis.Options{Addr: mr.Addr()}
+	client := NewRpcRedis(WithRedisOptions(options), WithRedisDialTimeout(3*time.Second))
+	defer client.Close()
+	if options.DialTimeout != 0 || client.Options == options {
+		t.Fatal("caller options modified")
+	}
+}
@@ -13,6 +13,7 @@
 package rpc
 
 import (
+	"context"
 	"time"
 
 	"github.com/fengyuqin/kungfu/v2/config"
@@ -40,24 +41,41 @@ type ServerRpc interface {
 	PublishBroadcast(s ReqBuilder) error                                              //broadcast publish
 	Request(s ReqBuilder) error                                                       //request
 	QueueRequest(s ReqBuilder) error                                                  //queue request
+	SendMsgWithContext(ctx context.Context, s ReqBuilder) error                       //send msg direct with context
+	PublishWithContext(ctx context.Context, s ReqBuilder) error                       //publish with context
+	QueuePublishWithContext(ctx context.Context, s ReqBuilder) error                  //queue publish with context
+	PublishBroadcastWithContext(ctx context.Context, s ReqBuilder) error              //broadcast publish with context
+	RequestWithContext(ctx context.Context, s ReqBuilder) error                       //request with context
+	QueueRequestWithContext(ctx context.Context, s ReqBuilder) error                  //queue request with context
+	RequestStream(ctx context.Context, s ReqBuilder) (*StreamReader, error)           //stream request
+	QueueRequestStream(ctx context.Context, s ReqBuilder) (*StreamReader, error)      //queue stream request
 	Response(codeType string, v any) []byte                                           //response the msg
+	ResponseError(codeType string, err error) []byte                                  //response the error
 	DecodeMsg(codeType string, data []byte, v any) error                              //decode msg
 	GetCoder(codeType string) EncoderRpc                                              //get encoder
 	GetServer() *treaty.Server                                                        //get current server
 	Find(serverType string, arg any, options ...discover.FilterOption) *treaty.Server //find server
 	RemoveFindCache(arg any)                                                          //clear find cache
+	GetFinder() *discover.Finder                                                      //get finder
+	UseClientInterceptors(interceptors ...ClientInterceptor)                          //outgoing interceptors
 	Close() error                                                                     //close option
 }
 
 // NewRpcServer create rpc server
 func NewRpcServer(cfg config.RpcConf, server *treaty.Server) ServerRpc {
 	timeout := time.Duration(cfg.DialTimeout) * time.Second
+	var signer *Signer
+	if len(cfg.SignKeys) > 0 {
+		signer = NewSigner(cfg.SignKeys, time.Duration(cfg.SignWindow)*time.Second)
+	}
 	var r ServerRpc
 	switch cfg.UseType {
 	case "nats":
 		r = NewRpcNats(
 			WithNatsEndpoints(cfg.Endpoints),
 			WithNatsDialTimeout(timeout),
+			WithNatsCompressThreshold(cfg.CompressThreshold),
+			WithNatsSigner(signer),
 			WithNatsOptions(nats.Timeout(timeout)),
 			WithNatsServer(server),
 			WithNatsPrefix(cfg.Prefix),
@@ -67,10 +85,31 @@ func NewRpcServer(cfg config.RpcConf, server *treaty.Server) ServerRpc {
 		r = NewRpcRabbitMq(
 			WithRabbitMqEndpoints(cfg.Endpoints),
 			WithRabbitMqDialTimeout(timeout),
+			WithRabbitMqCompressThreshold(cfg.CompressThreshold),
+			WithRabbitMqSigner(signer),
 			WithRabbitMqServer(server),
 			WithRabbitMqPrefix(cfg.Prefix),
 			WithRabbitMqDebugMsg(cfg.DebugMsg),
 		)
+	case "redis":
+		r = NewRpcRedis(
+			WithRedisEndpoints(cfg.Endpoints),
+			WithRedisDialTimeout(timeout),
+			WithRedisCompressThreshold(cfg.CompressThreshold),
+			WithRedisSigner(signer),
+			WithRedisServer(server),
+			WithRedisPrefix(cfg.Prefix),
+			WithRedisDebugMsg(cfg.DebugMsg),
+		)
+	case "local":
+		r = NewRpcLocal(
+			WithLocalDialTimeout(timeout),
+			WithLocalCompressThreshold(cfg.CompressThreshold),
+			WithLocalSigner(signer),
+			WithLocalServer(server),
+			WithLocalPrefix(cfg.Prefix),
+			WithLocalDebugMsg(cfg.DebugMsg),
+		)
 	default:
 		logger.Fatal("NewRpcConnector failed")
 	}
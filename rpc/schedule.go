@@ -0,0 +1,287 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrScheduleDisabled    = errors.New("rpc delayed delivery requires a scheduler")
+	ErrScheduleUnsupported = errors.New("rpc delayed delivery only supports one-way messages")
+)
+
+const (
+	DefaultScheduleKey      = "rpc_schedule"
+	DefaultScheduleInterval = 500 * time.Millisecond
+	DefaultScheduleLease    = 30 * time.Second
+	DefaultScheduleBatch    = 100
+)
+
+// scheduleClaim 将租约过期的投递中消息放回待投递,再取出到期消息并设置租约
+// KEYS[1]待投递 KEYS[2]投递中 ARGV[1]当前时间 ARGV[2]租约到期时间 ARGV[3]数量
+var scheduleClaim = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, member in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], member)
+	redis.call('ZADD', KEYS[1], ARGV[1], member)
+end
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[3])
+for _, member in ipairs(due) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZADD', KEYS[2], ARGV[2], member)
+end
+return due
+`)
+
+// scheduledMsg 延迟消息,消息体已序列化,投递时按原方法发送
+type scheduledMsg struct {
+	Id         string         `json:"id"`
+	Method     string         `json:"method"`
+	DeliverAt  int64          `json:"deliver_at"`
+	Queue      string         `json:"queue,omitempty"`
+	CodeType   string         `json:"code_type"`
+	Suffix     string         `json:"suffix,omitempty"`
+	Server     *treaty.Server `json:"server,omitempty"`
+	ServerType string         `json:"server_type,omitempty"`
+	MsgId      int32          `json:"msg_id"`
+	Data       []byte         `json:"data"`
+	Meta       Metadata       `json:"meta,omitempty"`
+	ExName     string         `json:"ex_name,omitempty"`
+	ExType     string         `json:"ex_type,omitempty"`
+	RtKey      string         `json:"rt_key,omitempty"`
+	Durable    bool           `json:"durable,omitempty"`
+}
+
+func (m *scheduledMsg) builder() ReqBuilder {
+	return ReqBuilder{
+		queue:      m.Queue,
+		codeType:   m.CodeType,
+		suffix:     m.Suffix,
+		server:     m.Server,
+		serverType: m.ServerType,
+		msgId:      m.MsgId,
+		req:        m.Data,
+		meta:       m.Meta,
+		exName:     m.ExName,
+		exType:     m.ExType,
+		rtKey:      m.RtKey,
+		durable:    m.Durable,
+	}
+}
+
+// Scheduler 延迟消息调度,消息存储于redis有序集合,进程重启不丢失,多个实例可共享同一集合
+type Scheduler struct {
+	Client   *redis.Client
+	Rpc      ServerRpc
+	Key      string        //待投递集合,投递中集合为Key:processing
+	Interval time.Duration //轮询间隔
+	Lease    time.Duration //投递租约,实例崩溃时租约到期后重新投递
+	Batch    int64         //每次最多取出的消息数
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+type SchedulerOption func(s *Scheduler)
+
+func WithScheduleKey(key string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.Key = key
+	}
+}
+func WithScheduleInterval(interval time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.Interval = interval
+	}
+}
+func WithScheduleLease(lease time.Duration) SchedulerOption {
+	return func(s *Scheduler) {
+		s.Lease = lease
+	}
+}
+func WithScheduleBatch(batch int64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.Batch = batch
+	}
+}
+
+// NewScheduler r为投递使用的rpc,需安装Interceptor后延迟消息才会写入调度
+func NewScheduler(r ServerRpc, client *redis.Client, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		Client:   client,
+		Rpc:      r,
+		Key:      DefaultScheduleKey,
+		Interval: DefaultScheduleInterval,
+		Lease:    DefaultScheduleLease,
+		Batch:    DefaultScheduleBatch,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+func (s *Scheduler) processingKey() string {
+	return s.Key + ":processing"
+}
+
+// Interceptor 设置了投递时间的单向消息写入调度,其他调用直接发送
+func (s *Scheduler) Interceptor() ClientInterceptor {
+	return func(ctx context.Context, method string, b ReqBuilder, next ClientInvoker) error {
+		if b.deliverAt.IsZero() {
+			return next(ctx, b)
+		}
+		switch method {
+		case MethodSendMsg, MethodPublish, MethodQueuePublish, MethodPublishBroadcast:
+			return s.schedule(ctx, method, b)
+		}
+		return ErrScheduleUnsupported
+	}
+}
+
+func (s *Scheduler) schedule(ctx context.Context, method string, b ReqBuilder) error {
+	data, ok := b.req.([]byte)
+	if !ok {
+		coder := s.Rpc.GetCoder(b.codeType)
+		if coder == nil {
+			return fmt.Errorf("rpc coder not exist:%v", b.codeType)
+		}
+		var err error
+		if data, err = coder.EncodeMsg(b.req); err != nil {
+			return err
+		}
+	}
+	msg := &scheduledMsg{
+		Id:         uuid.NewString(),
+		Method:     method,
+		DeliverAt:  b.deliverAt.UnixMilli(),
+		Queue:      b.queue,
+		CodeType:   b.codeType,
+		Suffix:     b.suffix,
+		Server:     b.server,
+		ServerType: b.serverType,
+		MsgId:      b.msgId,
+		Data:       data,
+		Meta:       b.meta,
+		ExName:     b.exName,
+		ExType:     b.exType,
+		RtKey:      b.rtKey,
+		Durable:    b.durable,
+	}
+	member, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return s.Client.ZAdd(ctx, s.Key, &redis.Z{Score: float64(msg.DeliverAt), Member: member}).Err()
+}
+
+// Start 开始轮询到期消息
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				//取满一批时可能还有到期消息,继续取出
+				for s.Poll() >= s.Batch && s.ctx.Err() == nil {
+					continue
+				}
+			}
+		}
+	}()
+}
+
+// Stop 停止轮询,已取出未投递的消息在租约到期后由其他实例投递
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Poll 取出并投递到期消息,返回取出的数量
+func (s *Scheduler) Poll() int64 {
+	now := time.Now()
+	res, err := scheduleClaim.Run(s.ctx, s.Client, []string{s.Key, s.processingKey()},
+		now.UnixMilli(), now.Add(s.Lease).UnixMilli(), s.Batch).StringSlice()
+	if err != nil {
+		if s.ctx.Err() == nil {
+			logger.Errorf("rpc schedule claim failed, key:%v, err:%v", s.Key, err)
+		}
+		return 0
+	}
+	for _, member := range res {
+		s.deliver(member)
+	}
+	return int64(len(res))
+}
+
+// deliver 投递成功后移出投递中集合,失败时等待租约到期重新投递
+func (s *Scheduler) deliver(member string) {
+	msg := &scheduledMsg{}
+	if err := json.Unmarshal([]byte(member), msg); err != nil {
+		logger.Errorf("rpc schedule msg invalid, drop it, err:%v", err)
+		s.done(member)
+		return
+	}
+	var err error
+	b := msg.builder()
+	//写入调度时已经过客户端拦截器,投递时不再重复统计及熔断
+	ctx := withRawInvoke(s.ctx)
+	switch msg.Method {
+	case MethodSendMsg:
+		err = s.Rpc.SendMsgWithContext(ctx, b)
+	case MethodPublish:
+		err = s.Rpc.PublishWithContext(ctx, b)
+	case MethodQueuePublish:
+		err = s.Rpc.QueuePublishWithContext(ctx, b)
+	case MethodPublishBroadcast:
+		err = s.Rpc.PublishBroadcastWithContext(ctx, b)
+	default:
+		err = fmt.Errorf("unknown method:%v", msg.Method)
+	}
+	if err != nil {
+		logger.Errorf("rpc schedule deliver failed, retry after lease, id:%v, msgId:%v, err:%v", msg.Id, msg.MsgId, err)
+		return
+	}
+	if late := time.Since(time.UnixMilli(msg.DeliverAt)); late > s.Lease {
+		logger.Warnf("rpc schedule deliver late, id:%v, msgId:%v, late:%v", msg.Id, msg.MsgId, late)
+	}
+	s.done(member)
+}
+
+func (s *Scheduler) done(member string) {
+	if err := s.Client.ZRem(s.ctx, s.processingKey(), member).Err(); err != nil {
+		logger.Errorf("rpc schedule remove failed, key:%v, err:%v", s.processingKey(), err)
+	}
+}
+
+// Pending 待投递消息数量
+func (s *Scheduler) Pending(ctx context.Context) (int64, error) {
+	return s.Client.ZCard(ctx, s.Key).Result()
+}
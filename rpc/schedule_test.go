@@ -0,0 +1,151 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestScheduleInterceptor(t *testing.T) {
+	server := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	r := NewRpcLocal(WithLocalBus(NewLocalBus()), WithLocalDialTimeout(time.Second))
+	b := NewReqBuilder(server).SetMsgId(1).SetReq(&treaty.LoginRequest{Uid: 1}).SetDelay(time.Minute)
+	//未安装调度时拒绝发送
+	if err := r.Publish(b.Build()); !errors.Is(err, ErrScheduleDisabled) {
+		t.Fatalf("want schedule disabled, got:%v", err)
+	}
+	r.UseClientInterceptors(NewScheduler(r, nil).Interceptor())
+	if err := r.RequestWithContext(context.Background(), b.Build()); !errors.Is(err, ErrScheduleUnsupported) {
+		t.Fatalf("want schedule unsupported, got:%v", err)
+	}
+}
+
+func TestScheduledMsg(t *testing.T) {
+	server := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	data, err := json.Marshal(&scheduledMsg{
+		Method: MethodQueuePublish, CodeType: CodeTypeJson, Suffix: JsonSuffix, Server: server,
+		ServerType: "backend", Queue: "q", MsgId: 3, Data: []byte(`{"uid":1}`), Meta: Metadata{MetaUid: "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &scheduledMsg{}
+	if err = json.Unmarshal(data, msg); err != nil {
+		t.Fatal(err)
+	}
+	b := msg.builder()
+	if b.msgId != 3 || b.queue != "q" || b.server.ServerId != "1001" || b.meta.Uid() != 1 || string(b.req.([]byte)) != `{"uid":1}` || !b.deliverAt.IsZero() {
+		t.Fatalf("builder not match:%+v", b)
+	}
+}
+
+func TestSchedulerClaim(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	bus := NewLocalBus()
+	server := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	var delivered int32
+	sub := NewRpcLocal(WithLocalBus(bus), WithLocalServer(server))
+	defer sub.Close()
+	callback := func(req *MsgRpc) []byte {
+		atomic.AddInt32(&delivered, 1)
+		return nil
+	}
+	if err := sub.Subscribe(NewRssBuilder(server).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetCallback(callback).Build()); err != nil {
+		t.Fatal(err)
+	}
+	//调度写入及到期投递各经过一次外层拦截器
+	var intercepted int32
+	r := NewRpcLocal(WithLocalBus(bus), WithLocalClientInterceptors(func(ctx context.Context, method string, s ReqBuilder, next ClientInvoker) error {
+		atomic.AddInt32(&intercepted, 1)
+		return next(ctx, s)
+	}))
+	defer r.Close()
+	const total = 50
+	schedulers := make([]*Scheduler, 4)
+	for i := range schedulers {
+		schedulers[i] = NewScheduler(r, client, WithScheduleBatch(7))
+	}
+	r.UseClientInterceptors(schedulers[0].Interceptor())
+	for i := 0; i < total; i++ {
+		b := NewReqBuilder(server).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetMsgId(1).
+			SetReq(&treaty.LoginRequest{Uid: int32(i)}).SetDelay(time.Millisecond)
+		if err := r.Publish(b.Build()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	//多个实例并发取出,每条消息只投递一次
+	var wg sync.WaitGroup
+	var claimed int64
+	for _, s := range schedulers {
+		wg.Add(1)
+		go func(s *Scheduler) {
+			defer wg.Done()
+			for n := s.Poll(); n > 0; n = s.Poll() {
+				atomic.AddInt64(&claimed, n)
+			}
+		}(s)
+	}
+	wg.Wait()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&delivered) < total && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if claimed != total || atomic.LoadInt32(&delivered) != total {
+		t.Fatalf("want %v delivered once, claimed:%v, delivered:%v", total, claimed, delivered)
+	}
+	if n := atomic.LoadInt32(&intercepted); n != total {
+		t.Fatalf("delivery should bypass client interceptors, intercepted:%v", n)
+	}
+	if n, _ := client.ZCard(context.Background(), schedulers[0].processingKey()).Result(); n != 0 {
+		t.Fatalf("processing set not empty:%v", n)
+	}
+}
+
+func TestSchedulerLeaseExpired(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	r := NewRpcLocal(WithLocalBus(NewLocalBus()))
+	defer r.Close()
+	s := NewScheduler(r, client)
+	server := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	member := func(id string) string {
+		data, _ := json.Marshal(&scheduledMsg{Id: id, Method: MethodPublish, CodeType: CodeTypeJson, Server: server, MsgId: 1, Data: []byte("{}")})
+		return string(data)
+	}
+	//模拟实例崩溃遗留在投递中集合的消息
+	ctx, now := context.Background(), time.Now()
+	client.ZAdd(ctx, s.processingKey(),
+		&redis.Z{Score: float64(now.Add(-time.Second).UnixMilli()), Member: member("expired")},
+		&redis.Z{Score: float64(now.Add(time.Minute).UnixMilli()), Member: member("leased")})
+	if n := s.Poll(); n != 1 {
+		t.Fatalf("want expired lease reclaimed, got:%v", n)
+	}
+	left, err := client.ZRange(ctx, s.processingKey(), 0, -1).Result()
+	if err != nil || len(left) != 1 || left[0] != member("leased") {
+		t.Fatalf("leased msg should stay, left:%v, err:%v", left, err)
+	}
+}
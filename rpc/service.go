@@ -0,0 +1,136 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/fengyuqin/kungfu/v2/logger"
+)
+
+// MsgIdFunc 由方法名得到msgId
+type MsgIdFunc func(method string) (int32, bool)
+
+type serviceOptions struct {
+	msgIds    map[string]int32
+	msgIdFunc MsgIdFunc
+}
+
+type ServiceOption func(o *serviceOptions)
+
+// WithServiceMsgIds 按方法名指定msgId,可使用protoc-gen-kungfu生成的<Service>_MsgIds
+func WithServiceMsgIds(msgIds map[string]int32) ServiceOption {
+	return func(o *serviceOptions) {
+		o.msgIds = msgIds
+	}
+}
+
+// WithServiceMsgIdFunc 启用命名规则,未在WithServiceMsgIds中指定的方法按规则得到msgId,如MsgIdBySuffix
+func WithServiceMsgIdFunc(fn MsgIdFunc) ServiceOption {
+	return func(o *serviceOptions) {
+		o.msgIdFunc = fn
+	}
+}
+
+// MsgIdSuffixDigits MsgIdBySuffix要求的最少数字位数,避免Retry3、EncodeV2等辅助方法被注册
+const MsgIdSuffixDigits = 4
+
+// MsgIdBySuffix 命名规则,方法名以至少MsgIdSuffixDigits位的msgId结尾,如Login1001
+func MsgIdBySuffix(method string) (int32, bool) {
+	digits := method[len(strings.TrimRightFunc(method, func(r rune) bool { return r >= '0' && r <= '9' })):]
+	if len(digits) < MsgIdSuffixDigits || len(digits) == len(method) {
+		return 0, false
+	}
+	msgId, err := strconv.ParseInt(digits, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(msgId), true
+}
+
+// RegisterService 注册结构体的全部处理方法,方法签名同Register及RegisterStream,
+// msgId优先取WithServiceMsgIds指定的,其次按WithServiceMsgIdFunc启用的命名规则,得不到msgId的方法忽略
+func (h *Handler) RegisterService(v any, opts ...ServiceOption) error {
+	o := &serviceOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if v == nil {
+		return errors.New("rpc service is nil")
+	}
+	vf, tf := reflect.ValueOf(v), reflect.TypeOf(v)
+	name := reflect.Indirect(vf).Type().Name()
+	for method := range o.msgIds {
+		if _, ok := tf.MethodByName(method); !ok {
+			return fmt.Errorf("rpc service %v has no method %v", name, method)
+		}
+	}
+	//先校验全部方法再注册,校验失败时不注册任何方法
+	type serviceMethod struct {
+		msgId  int32
+		name   string
+		fn     reflect.Value
+		stream bool
+		byFunc bool //由命名规则得到msgId
+	}
+	var methods []serviceMethod
+	names := make(map[int32]string)
+	for i := 0; i < tf.NumMethod(); i++ {
+		method := tf.Method(i)
+		msgId, explicit := o.msgIds[method.Name]
+		if !explicit {
+			var ok bool
+			if o.msgIdFunc == nil {
+				continue
+			}
+			if msgId, ok = o.msgIdFunc(method.Name); !ok {
+				continue
+			}
+		}
+		fn := vf.Method(i)
+		stream := h.isSuitStreamHandler(fn.Type())
+		if !stream && !h.isSuitHandler(fn.Type()) {
+			if explicit {
+				return fmt.Errorf("rpc service %v.%v is not suit handler", name, method.Name)
+			}
+			logger.Warnf("rpc service %v.%v is not suit handler, skip it", name, method.Name)
+			continue
+		}
+		if _, ok := h.handlers[msgId]; ok {
+			return fmt.Errorf("rpc service %v.%v msgId has already been registered:%v", name, method.Name, msgId)
+		}
+		if other, ok := names[msgId]; ok {
+			return fmt.Errorf("rpc service %v.%v msgId conflicts with %v:%v", name, method.Name, other, msgId)
+		}
+		names[msgId] = method.Name
+		methods = append(methods, serviceMethod{msgId: msgId, name: method.Name, fn: fn, stream: stream, byFunc: !explicit})
+	}
+	if len(methods) < 1 {
+		return fmt.Errorf("rpc service %v has no suit handler method", name)
+	}
+	for _, m := range methods {
+		if m.byFunc {
+			logger.Infof("rpc service %v.%v registered by naming rule, msgId:%v", name, m.name, m.msgId)
+		}
+		if m.stream {
+			h.RegisterStream(m.msgId, m.fn.Interface())
+		} else {
+			h.Register(m.msgId, m.fn.Interface())
+		}
+	}
+	return nil
+}
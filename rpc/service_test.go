@@ -0,0 +1,100 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+type LoginService struct {
+	prefix string
+}
+
+func (s *LoginService) Login1001(ctx context.Context, req *treaty.LoginRequest) (*treaty.LoginResponse, error) {
+	if req.Token != "ok" {
+		return nil, NewRemoteError(401, "token invalid")
+	}
+	return &treaty.LoginResponse{Msg: s.prefix + req.Token}, nil
+}
+
+func (s *LoginService) Logout(req *treaty.LoginRequest) {}
+
+func (s *LoginService) Members1002(req *treaty.LoginRequest, stream *StreamSender) error {
+	return nil
+}
+
+// Version1004 签名不符的方法按命名规则匹配时忽略
+func (s *LoginService) Version1004() string {
+	return "v1"
+}
+
+// Retry3 数字位数不足的辅助方法不按命名规则注册
+func (s *LoginService) Retry3(req *treaty.LoginRequest) {}
+
+func TestHandlerRegisterService(t *testing.T) {
+	h, r := NewHandler(), newHandlerTestRpc()
+	if err := h.RegisterService(&LoginService{prefix: "hi "}, WithServiceMsgIds(map[string]int32{"Logout": 1003}), WithServiceMsgIdFunc(MsgIdBySuffix)); err != nil {
+		t.Fatal(err)
+	}
+	resp := &treaty.LoginResponse{}
+	if err := r.request(h, 1001, &treaty.LoginRequest{Token: "ok"}, resp); err != nil || resp.Msg != "hi ok" {
+		t.Fatalf("request failed, err:%v, resp:%+v", err, resp)
+	}
+	if err := r.request(h, 1001, &treaty.LoginRequest{Token: "bad"}, resp); err == nil {
+		t.Fatal("want remote error")
+	}
+	for msgId, msgType := range map[int32]MessageType{1001: MsgTypeRequest, 1002: MsgTypeStream, 1003: MsgTypePublish} {
+		if item, ok := h.handlers[msgId]; !ok || item.MsgType != msgType {
+			t.Fatalf("msgId %v not registered as %v", msgId, msgType)
+		}
+	}
+	if len(h.handlers) != 3 {
+		t.Fatalf("unexpected handlers:%v", len(h.handlers))
+	}
+
+	if err := NewHandler().RegisterService(&LoginService{}, WithServiceMsgIds(map[string]int32{"Version1004": 1})); err == nil {
+		t.Fatal("want not suit handler error")
+	}
+	if err := NewHandler().RegisterService(&LoginService{}, WithServiceMsgIds(map[string]int32{"Missing": 1})); err == nil {
+		t.Fatal("want missing method error")
+	}
+	if err := h.RegisterService(&LoginService{}, WithServiceMsgIdFunc(MsgIdBySuffix)); err == nil {
+		t.Fatal("want duplicate msgId error")
+	}
+	//未启用命名规则时只注册指定的方法
+	explicit := NewHandler()
+	if err := explicit.RegisterService(&LoginService{}, WithServiceMsgIds(map[string]int32{"Logout": 1003})); err != nil || len(explicit.handlers) != 1 {
+		t.Fatalf("want explicit registration only, err:%v, handlers:%v", err, len(explicit.handlers))
+	}
+	//校验失败时不注册任何方法
+	partial := NewHandler()
+	partial.Register(1002, func(req *treaty.LoginRequest) {})
+	if err := partial.RegisterService(&LoginService{}, WithServiceMsgIdFunc(MsgIdBySuffix)); err == nil || len(partial.handlers) != 1 {
+		t.Fatalf("want no partial registration, err:%v, handlers:%v", err, len(partial.handlers))
+	}
+	if err := NewHandler().RegisterService(&LoginService{}, WithServiceMsgIds(map[string]int32{"Logout": 1001}), WithServiceMsgIdFunc(MsgIdBySuffix)); err == nil {
+		t.Fatal("want msgId conflict error")
+	}
+	if msgId, ok := MsgIdBySuffix("Login1001"); !ok || msgId != 1001 {
+		t.Fatalf("unexpected msgId:%v", msgId)
+	}
+	if _, ok := MsgIdBySuffix("1001"); ok {
+		t.Fatal("method name without prefix should not match")
+	}
+	if _, ok := MsgIdBySuffix("EncodeV2"); ok {
+		t.Fatal("short suffix should not match")
+	}
+}
@@ -0,0 +1,178 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrSignMissing  = errors.New("rpc frame not signed")
+	ErrSignInvalid  = errors.New("rpc frame sign invalid")
+	ErrSignExpired  = errors.New("rpc frame sign expired")
+	ErrSignReplayed = errors.New("rpc frame replayed")
+	ErrSignBusy     = errors.New("rpc frame nonce cache full")
+)
+
+const (
+	signLength        = 0x30 //时间戳8字节 + nonce8字节 + mac32字节
+	signMacOffset     = 0x10
+	DefaultSignWindow = 30 * time.Second
+	DefaultSignNonces = 1 << 20 //时间窗口内最多记录的nonce数
+)
+
+// SignerRpc 支持签名的rpc实现
+type SignerRpc interface {
+	GetSigner() *Signer //未开启签名时返回nil
+}
+
+// Signer rpc帧HMAC签名,使用第一个key签名,任一key校验通过即可,用于不停服轮换key
+type Signer struct {
+	keys      [][]byte
+	window    time.Duration                 //时间戳允许偏差,同时为nonce保留时长
+	buckets   map[int64]map[uint64]struct{} //按帧时间戳/window分桶的nonce,过期的桶整体丢弃
+	count     int                           //当前记录的nonce数
+	maxNonces int                           //nonce记录上限,达到后拒绝新帧直到旧桶过期
+	lock      sync.RWMutex
+}
+
+type SignerOption func(s *Signer)
+
+// WithSignMaxNonces 时间窗口内最多记录的nonce数,超过时拒绝新帧,避免大量不同nonce的帧耗尽内存
+func WithSignMaxNonces(n int) SignerOption {
+	return func(s *Signer) {
+		s.maxNonces = n
+	}
+}
+
+func NewSigner(keys []string, window time.Duration, opts ...SignerOption) *Signer {
+	if window <= 0 {
+		window = DefaultSignWindow
+	}
+	s := &Signer{
+		window:    window,
+		buckets:   make(map[int64]map[uint64]struct{}),
+		maxNonces: DefaultSignNonces,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.SetKeys(keys)
+	return s
+}
+
+// SetKeys 运行时轮换key,先下发[旧,新]使所有节点接受新key,再下发[新,旧]切换签名key,最后移除旧key
+func (s *Signer) SetKeys(keys []string) {
+	res := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if len(key) > 0 {
+			res = append(res, []byte(key))
+		}
+	}
+	s.lock.Lock()
+	s.keys = res
+	s.lock.Unlock()
+}
+
+func (s *Signer) mac(key []byte, frame []byte, head int) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(frame[:head+signMacOffset])
+	h.Write(frame[head+signLength:])
+	return h.Sum(nil)
+}
+
+// sign 填充签名段,frame的签名段已预留,head为帧头长度
+func (s *Signer) sign(frame []byte, head int) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if len(s.keys) < 1 {
+		return ErrSignMissing
+	}
+	section := frame[head : head+signLength]
+	binary.BigEndian.PutUint64(section, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(section[8:signMacOffset]); err != nil {
+		return err
+	}
+	copy(section[signMacOffset:], s.mac(s.keys[0], frame, head))
+	return nil
+}
+
+// verify 校验签名,replay为true时同时校验时间窗口及nonce
+func (s *Signer) verify(frame []byte, head int, replay bool) error {
+	section := frame[head : head+signLength]
+	s.lock.RLock()
+	valid := false
+	for _, key := range s.keys {
+		if hmac.Equal(section[signMacOffset:], s.mac(key, frame, head)) {
+			valid = true
+			break
+		}
+	}
+	s.lock.RUnlock()
+	if !valid {
+		return ErrSignInvalid
+	}
+	if !replay {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	ts := int64(binary.BigEndian.Uint64(section))
+	if ts < now-int64(s.window) || ts > now+int64(s.window) {
+		return ErrSignExpired
+	}
+	nonce := binary.BigEndian.Uint64(section[8:signMacOffset])
+	window := int64(s.window)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	//桶内帧的时间戳均早于now-window时,重放会因过期被拒绝,无需再记录
+	for k, bucket := range s.buckets {
+		if (k+1)*window <= now-window {
+			s.count -= len(bucket)
+			delete(s.buckets, k)
+		}
+	}
+	bucket := s.buckets[ts/window]
+	if _, ok := bucket[nonce]; ok {
+		return ErrSignReplayed
+	}
+	if s.maxNonces > 0 && s.count >= s.maxNonces {
+		return ErrSignBusy
+	}
+	if bucket == nil {
+		bucket = make(map[uint64]struct{})
+		s.buckets[ts/window] = bucket
+	}
+	bucket[nonce] = struct{}{}
+	s.count++
+	return nil
+}
+
+// setSignKeys 轮换rpc签名key
+func setSignKeys(r ServerRpc, keys []string) error {
+	sr, ok := r.(SignerRpc)
+	if !ok || sr.GetSigner() == nil {
+		return fmt.Errorf("rpc sign not enabled:%T", r)
+	}
+	if len(keys) < 1 {
+		return ErrSignMissing
+	}
+	sr.GetSigner().SetKeys(keys)
+	return nil
+}
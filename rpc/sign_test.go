@@ -0,0 +1,108 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestRpcEncoderSign(t *testing.T) {
+	sender := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"k1"}, time.Second)))
+	receiver := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"k2", "k1"}, time.Second)))
+	msg := &MsgRpc{MsgType: MsgTypeRequest, MsgId: 1, MsgData: &treaty.LoginRequest{Uid: 1}, Meta: Metadata{MetaUid: "1"}}
+	data, err := sender.Encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &treaty.LoginRequest{}
+	if err = receiver.Decode(data, &MsgRpc{MsgData: res}); err != nil || res.Uid != 1 {
+		t.Fatalf("decode signed frame failed, err:%v, res:%+v", err, res)
+	}
+	//重放
+	if err = receiver.Decode(data, &MsgRpc{}); !errors.Is(err, ErrSignReplayed) {
+		t.Fatalf("want replayed, got:%v", err)
+	}
+	//持久化消息重复投递
+	if err = receiver.decodeStored(data, &MsgRpc{}); err != nil {
+		t.Fatal(err)
+	}
+	//篡改
+	data, _ = sender.Encode(msg)
+	data[len(data)-2] ^= 0xFF
+	if err = receiver.Decode(data, &MsgRpc{}); !errors.Is(err, ErrSignInvalid) {
+		t.Fatalf("want invalid, got:%v", err)
+	}
+	//未签名
+	data, _ = NewRpcEncoder(serialize.NewJsonSerializer()).Encode(msg)
+	if err = receiver.Decode(data, &MsgRpc{}); !errors.Is(err, ErrSignMissing) {
+		t.Fatalf("want missing, got:%v", err)
+	}
+	//未知key
+	data, _ = NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"k3"}, time.Second))).Encode(msg)
+	if err = receiver.Decode(data, &MsgRpc{}); !errors.Is(err, ErrSignInvalid) {
+		t.Fatalf("want invalid, got:%v", err)
+	}
+	//过期
+	data, _ = sender.Encode(msg)
+	time.Sleep(1100 * time.Millisecond)
+	if err = receiver.Decode(data, &MsgRpc{}); !errors.Is(err, ErrSignExpired) {
+		t.Fatalf("want expired, got:%v", err)
+	}
+}
+
+func TestSignerNonceBound(t *testing.T) {
+	signer := NewSigner([]string{"k1"}, 100*time.Millisecond, WithSignMaxNonces(2))
+	coder := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(signer))
+	msg := &MsgRpc{MsgType: MsgTypePublish, MsgId: 1, MsgData: &treaty.LoginRequest{Uid: 1}}
+	for i := 0; i < 2; i++ {
+		data, _ := coder.Encode(msg)
+		if err := coder.Decode(data, &MsgRpc{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//达到上限后拒绝新帧
+	data, _ := coder.Encode(msg)
+	if err := coder.Decode(data, &MsgRpc{}); !errors.Is(err, ErrSignBusy) {
+		t.Fatalf("want busy, got:%v", err)
+	}
+	//时间窗口过后旧桶丢弃
+	time.Sleep(300 * time.Millisecond)
+	data, _ = coder.Encode(msg)
+	if err := coder.Decode(data, &MsgRpc{}); err != nil {
+		t.Fatal(err)
+	}
+	if signer.count != 1 || len(signer.buckets) != 1 {
+		t.Fatalf("expired buckets not dropped, count:%v, buckets:%v", signer.count, len(signer.buckets))
+	}
+}
+
+func TestSetSignKeys(t *testing.T) {
+	r := NewRpcLocal(WithLocalBus(NewLocalBus()), WithLocalSigner(NewSigner([]string{"k1"}, time.Second)))
+	defer r.Close()
+	if err := setSignKeys(r, []string{"k2", "k1"}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := NewRpcEncoder(serialize.NewJsonSerializer(), WithSigner(NewSigner([]string{"k1"}, time.Second))).
+		Encode(&MsgRpc{MsgType: MsgTypePublish, MsgId: 1})
+	if err := r.GetCoder(CodeTypeJson).Decode(data, &MsgRpc{}); err != nil {
+		t.Fatalf("old key should still verify, err:%v", err)
+	}
+	if err := setSignKeys(NewRpcLocal(WithLocalBus(NewLocalBus())), []string{"k1"}); err == nil {
+		t.Fatal("want error for rpc without signer")
+	}
+}
@@ -0,0 +1,272 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+var (
+	ErrStreamClosed   = errors.New("rpc stream closed")
+	ErrStreamOverflow = errors.New("rpc stream buffer overflow")
+	ErrStreamGap      = errors.New("rpc stream chunk lost")
+)
+
+// DefaultStreamBuffer 客户端每个流缓冲的分片数
+const DefaultStreamBuffer = 256
+
+// StreamSender 服务端流式响应发送器,每次Send发送一个响应分片,
+// 分片及结束标记按发送顺序编号,读取方据此发现丢失的分片
+type StreamSender struct {
+	msgId int32
+	coder EncoderRpc
+	send  func(data []byte) error
+	seq   uint64
+	lock  sync.Mutex
+}
+
+func (s *StreamSender) Send(v any) error {
+	return s.write(MsgTypeStream, v)
+}
+
+// end 发送流结束标记
+func (s *StreamSender) end() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	return s.encode(MsgTypeStreamEnd, nil)
+}
+
+func (s *StreamSender) write(msgType MessageType, v any) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.seq++
+	data, err := s.encode(msgType, v)
+	if err != nil {
+		s.seq--
+		return err
+	}
+	return s.send(data)
+}
+
+func (s *StreamSender) encode(msgType MessageType, v any) ([]byte, error) {
+	return s.coder.Encode(&MsgRpc{
+		MsgType: msgType,
+		MsgId:   s.msgId,
+		MsgData: v,
+		Meta:    Metadata{MetaStreamSeq: strconv.FormatUint(s.seq, 10)},
+	})
+}
+
+type streamCtxKey struct{}
+
+func withStreamSender(ctx context.Context, sender *StreamSender) context.Context {
+	return context.WithValue(ctx, streamCtxKey{}, sender)
+}
+
+func streamSenderFromContext(ctx context.Context) *StreamSender {
+	sender, _ := ctx.Value(streamCtxKey{}).(*StreamSender)
+	return sender
+}
+
+// streamFrameDone 是否为流的最后一帧,结束标记及错误帧均结束流
+func streamFrameDone(data []byte) bool {
+	if len(data) < msgHeadLength {
+		return true
+	}
+	return MessageType(data[3]&^msgFlagMask) != MsgTypeStream
+}
+
+// StreamReader 客户端流式响应读取器,Recv依次读取分片,流结束返回io.EOF
+type StreamReader struct {
+	coder  EncoderRpc
+	frames chan []byte
+	seq    uint64        //已读取的分片编号
+	idle   time.Duration //分片间最大间隔,0表示不限制
+	ctx    context.Context
+	cancel context.CancelFunc
+	closer func()
+	err    error
+	once   sync.Once
+	lock   sync.Mutex
+}
+
+func newStreamReader(ctx context.Context, coder EncoderRpc, idle time.Duration, buffer int) *StreamReader {
+	if buffer < 1 {
+		buffer = DefaultStreamBuffer
+	}
+	s := &StreamReader{
+		coder:  coder,
+		frames: make(chan []byte, buffer),
+		idle:   idle,
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	return s
+}
+
+// push 投递分片,阻塞直到读取方接收或流关闭,用于每个流独占的订阅,
+// 读取过慢导致传输层丢弃分片时,读取方按分片编号发现缺失并以ErrStreamGap失败
+func (s *StreamReader) push(data []byte) bool {
+	select {
+	case s.frames <- data:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// offer 非阻塞投递分片,用于多个请求共享的回复队列,不能阻塞其他请求的回复,
+// 缓冲区满时流以ErrStreamOverflow失败,读取较慢且分片较多时需调大缓冲
+func (s *StreamReader) offer(data []byte) bool {
+	select {
+	case s.frames <- data:
+		return true
+	case <-s.ctx.Done():
+		return false
+	default:
+		s.fail(ErrStreamOverflow)
+		return false
+	}
+}
+
+// fail 以指定错误结束流,已结束的流保持原错误
+func (s *StreamReader) fail(err error) {
+	s.lock.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.lock.Unlock()
+	s.Close()
+}
+
+func (s *StreamReader) getErr() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Recv 读取下一个分片到v,流正常结束返回io.EOF,远程错误返回*RemoteError
+func (s *StreamReader) Recv(v any) error {
+	var idle <-chan time.Time
+	if s.idle > 0 {
+		timer := time.NewTimer(s.idle)
+		defer timer.Stop()
+		idle = timer.C
+	}
+	select {
+	case data := <-s.frames:
+		return s.decode(data, v)
+	default:
+	}
+	if err := s.getErr(); err != nil {
+		return err
+	}
+	select {
+	case data := <-s.frames:
+		return s.decode(data, v)
+	case <-idle:
+		s.fail(fmt.Errorf("rpc stream idle timeout:%w", context.DeadlineExceeded))
+	case <-s.ctx.Done():
+		s.fail(s.ctx.Err())
+	}
+	return s.getErr()
+}
+
+func (s *StreamReader) decode(data []byte, v any) error {
+	msg := &MsgRpc{}
+	if err := s.coder.Decode(data, msg); err != nil {
+		s.fail(err)
+		return s.getErr()
+	}
+	//未编号的分片来自旧版本服务端,不校验
+	if v, ok := msg.Meta[MetaStreamSeq]; ok {
+		seq, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || seq != s.seq+1 {
+			s.fail(fmt.Errorf("%w, want:%v, got:%v", ErrStreamGap, s.seq+1, v))
+			return s.getErr()
+		}
+		s.seq = seq
+	}
+	switch msg.MsgType {
+	case MsgTypeStream:
+		return s.coder.DecodeMsg(msg.MsgData.([]byte), v)
+	case MsgTypeStreamEnd:
+		s.fail(io.EOF)
+	default:
+		s.fail(fmt.Errorf("rpc stream unexpected msg type:%v", msg.MsgType))
+	}
+	return s.getErr()
+}
+
+// Close 结束读取并释放订阅,未读完的分片将被丢弃
+func (s *StreamReader) Close() {
+	s.once.Do(func() {
+		s.cancel()
+		if s.closer != nil {
+			s.closer()
+		}
+	})
+}
+
+// StreamIter 类型化的流式响应迭代器
+type StreamIter[Resp any] struct {
+	*StreamReader
+}
+
+// Next 返回下一个响应,流正常结束返回io.EOF
+func (s *StreamIter[Resp]) Next() (*Resp, error) {
+	resp := new(Resp)
+	if err := s.Recv(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CallStream 使用默认rpc向指定服务器发起流式请求
+func CallStream[Req, Resp any](ctx context.Context, server *treaty.Server, msgId int32, req *Req, opts ...CallOption) (*StreamIter[Resp], error) {
+	return CallStreamWith[Req, Resp](ctx, defRpc, server, msgId, req, opts...)
+}
+
+// CallStreamWith 使用指定rpc向指定服务器发起流式请求,调用方读取完毕或放弃时需Close
+func CallStreamWith[Req, Resp any](ctx context.Context, r ServerRpc, server *treaty.Server, msgId int32, req *Req, opts ...CallOption) (*StreamIter[Resp], error) {
+	b := NewReqBuilder(server).SetMsgId(msgId).SetReq(req)
+	for _, opt := range opts {
+		opt(b)
+	}
+	reader, err := r.RequestStream(ctx, b.Build())
+	if err != nil {
+		return nil, err
+	}
+	return &StreamIter[Resp]{StreamReader: reader}, nil
+}
+
+// CallQueueStreamWith 使用指定rpc向指定类型服务器的队列发起流式请求
+func CallQueueStreamWith[Req, Resp any](ctx context.Context, r ServerRpc, serverType string, msgId int32, req *Req, opts ...CallOption) (*StreamIter[Resp], error) {
+	b := DefaultReqBuilder().SetServerType(serverType).SetMsgId(msgId).SetReq(req)
+	for _, opt := range opts {
+		opt(b)
+	}
+	reader, err := r.QueueRequestStream(ctx, b.Build())
+	if err != nil {
+		return nil, err
+	}
+	return &StreamIter[Resp]{StreamReader: reader}, nil
+}
@@ -0,0 +1,136 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fengyuqin/kungfu/v2/serialize"
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestLocalStream(t *testing.T) {
+	bus := NewLocalBus()
+	server := &treaty.Server{ServerId: "1001", ServerType: "backend"}
+	r := NewRpcLocal(WithLocalBus(bus), WithLocalServer(server))
+	defer r.Close()
+	h := NewHandler()
+	h.RegisterStream(1, func(ctx context.Context, req *treaty.LoginRequest, stream *StreamSender) error {
+		if req.Uid == 0 {
+			return NewRemoteError(1001, "uid empty")
+		}
+		for i := 0; i < 3; i++ {
+			if err := stream.Send(&treaty.LoginResponse{Msg: strconv.Itoa(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	callback := func(req *MsgRpc) []byte {
+		resp, err := h.DealMsg(CodeTypeJson, r, req)
+		if err != nil {
+			return r.ResponseError(CodeTypeJson, err)
+		}
+		return resp
+	}
+	if err := r.Subscribe(NewRssBuilder(server).SetCodeType(CodeTypeJson).SetSuffix(JsonSuffix).SetCallback(callback).Build()); err != nil {
+		t.Fatal(err)
+	}
+	client := NewRpcLocal(WithLocalBus(bus), WithLocalDialTimeout(time.Second))
+	ctx := context.Background()
+	iter, err := CallStreamWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, server, 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		resp, err := iter.Next()
+		if err == io.EOF {
+			if i != 3 {
+				t.Fatalf("stream chunk count not match:%v", i)
+			}
+			break
+		}
+		if err != nil || resp.Msg != strconv.Itoa(i) {
+			t.Fatalf("stream chunk failed, err:%v, resp:%+v", err, resp)
+		}
+	}
+	iter.Close()
+	//处理器错误结束流
+	iter, err = CallStreamWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, server, 1, &treaty.LoginRequest{}, WithCallJson())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	if _, err = iter.Next(); err == nil {
+		t.Fatal("want remote error")
+	} else if re, ok := AsRemoteError(err); !ok || re.Code != 1001 {
+		t.Fatalf("want remote error, got:%v", err)
+	}
+	//普通请求不能调用流式处理器
+	_, err = CallWith[treaty.LoginRequest, treaty.LoginResponse](ctx, client, server, 1, &treaty.LoginRequest{Uid: 1}, WithCallJson())
+	if re, ok := AsRemoteError(err); !ok || re.Code != ErrCodeBadRequest {
+		t.Fatalf("want bad request, got:%v", err)
+	}
+}
+
+func TestStreamGap(t *testing.T) {
+	coder := NewRpcEncoder(serialize.NewJsonSerializer())
+	var frames [][]byte
+	sender := &StreamSender{msgId: 1, coder: coder, send: func(data []byte) error {
+		frames = append(frames, data)
+		return nil
+	}}
+	for i := 0; i < 3; i++ {
+		if err := sender.Send(&treaty.LoginResponse{Msg: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	end, err := sender.end()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames = append(frames, end)
+	//完整的流正常结束
+	reader := newStreamReader(context.Background(), coder, 0, len(frames))
+	for _, frame := range frames {
+		reader.push(frame)
+	}
+	resp := &treaty.LoginResponse{}
+	for i := 0; i < 3; i++ {
+		if err = reader.Recv(resp); err != nil || resp.Msg != strconv.Itoa(i) {
+			t.Fatalf("stream chunk failed, err:%v, resp:%+v", err, resp)
+		}
+	}
+	if err = reader.Recv(resp); err != io.EOF {
+		t.Fatalf("want eof, got:%v", err)
+	}
+	//丢失中间分片时流失败,不会正常结束
+	reader = newStreamReader(context.Background(), coder, 0, len(frames))
+	for _, frame := range append(frames[:1:1], frames[2:]...) {
+		reader.push(frame)
+	}
+	if err = reader.Recv(resp); err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Recv(resp); !errors.Is(err, ErrStreamGap) {
+		t.Fatalf("want stream gap, got:%v", err)
+	}
+	if err = reader.Recv(resp); !errors.Is(err, ErrStreamGap) {
+		t.Fatalf("failed stream should keep error, got:%v", err)
+	}
+}
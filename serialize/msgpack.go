@@ -0,0 +1,46 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package serialize
+
+import (
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// MsgpackSerializer implements the serialize.Serializer interface
+type MsgpackSerializer struct {
+	handle *codec.MsgpackHandle
+}
+
+// NewMsgpackSerializer returns a new Serializer.
+func NewMsgpackSerializer() *MsgpackSerializer {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true    // use the str8 and bin types
+	h.RawToString = true // decode raw bytes into string when the target is any
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	return &MsgpackSerializer{handle: h}
+}
+
+// Marshal returns the MessagePack encoding of v.
+func (s *MsgpackSerializer) Marshal(v any) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, s.handle).Encode(v)
+	return data, err
+}
+
+// Unmarshal parses the MessagePack-encoded data and stores the result
+// in the value pointed to by v.
+func (s *MsgpackSerializer) Unmarshal(data []byte, v any) error {
+	return codec.NewDecoderBytes(data, s.handle).Decode(v)
+}
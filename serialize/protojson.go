@@ -0,0 +1,52 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package serialize
+
+import (
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ProtoJsonSerializer implements the serialize.Serializer interface,
+// proto messages are encoded as canonical JSON with proto field names.
+type ProtoJsonSerializer struct {
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+}
+
+// NewProtoJsonSerializer returns a new Serializer.
+func NewProtoJsonSerializer() *ProtoJsonSerializer {
+	return &ProtoJsonSerializer{
+		marshal:   protojson.MarshalOptions{UseProtoNames: true},
+		unmarshal: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+}
+
+// Marshal returns the protojson encoding of v.
+func (s *ProtoJsonSerializer) Marshal(v any) ([]byte, error) {
+	pb, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrWrongValueType
+	}
+	return s.marshal.Marshal(proto.MessageV2(pb))
+}
+
+// Unmarshal parses the protojson-encoded data and stores the result
+// in the value pointed to by v.
+func (s *ProtoJsonSerializer) Unmarshal(data []byte, v any) error {
+	pb, ok := v.(proto.Message)
+	if !ok {
+		return ErrWrongValueType
+	}
+	return s.unmarshal.Unmarshal(data, proto.MessageV2(pb))
+}
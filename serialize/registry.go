@@ -0,0 +1,69 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package serialize
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 内置序列化名称
+const (
+	Proto     = "proto"
+	Json      = "json"
+	Msgpack   = "msgpack"
+	ProtoJson = "protojson"
+)
+
+var (
+	registry     = make(map[string]Serializer)
+	registryLock sync.RWMutex
+)
+
+func init() {
+	Register(Proto, NewProtoSerializer())
+	Register(Json, NewJsonSerializer())
+	Register(Msgpack, NewMsgpackSerializer())
+	Register(ProtoJson, NewProtoJsonSerializer())
+}
+
+// Register registers the serializer by name, an existing one with the same name is replaced.
+// rpc encoders are created from the registry, so register before creating rpc servers.
+func Register(name string, s Serializer) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry[name] = s
+}
+
+// Get returns the serializer registered by name.
+func Get(name string) (Serializer, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	if s, ok := registry[name]; ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("serializer not registered:%v", name)
+}
+
+// Names returns the sorted names of all registered serializers.
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,72 @@
+/*
+ * +----------------------------------------------------------------------
+ *  | kungfu [ A FAST GAME FRAMEWORK ]
+ *  +----------------------------------------------------------------------
+ *  | Copyright (c) 2023-2029 All rights reserved.
+ *  +----------------------------------------------------------------------
+ *  | Licensed ( http:www.apache.org/licenses/LICENSE-2.0 )
+ *  +----------------------------------------------------------------------
+ *  | Author: jqiris <1920624985@qq.com>
+ *  +----------------------------------------------------------------------
+ */
+
+package serialize
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fengyuqin/kungfu/v2/treaty"
+)
+
+func TestRegistry(t *testing.T) {
+	for _, name := range []string{Proto, Json, Msgpack, ProtoJson} {
+		if _, err := Get(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Get("xml"); err == nil {
+		t.Fatal("want not registered error")
+	}
+}
+
+func TestMsgpackSerializer(t *testing.T) {
+	s, _ := Get(Msgpack)
+	type item struct {
+		Name  string
+		Count int
+		Tags  map[string]any
+	}
+	data, err := s.Marshal(&item{Name: "kungfu", Count: 3, Tags: map[string]any{"a": "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &item{}
+	if err = s.Unmarshal(data, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Name != "kungfu" || res.Count != 3 || res.Tags["a"] != "b" {
+		t.Fatalf("unexpected result:%+v", res)
+	}
+}
+
+func TestProtoJsonSerializer(t *testing.T) {
+	s, _ := Get(ProtoJson)
+	data, err := s.Marshal(&treaty.Server{ServerId: "game_1", ClientPort: 8080})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "server_id") || !strings.Contains(string(data), "client_port") {
+		t.Fatalf("proto field names not used:%s", data)
+	}
+	res := &treaty.Server{}
+	if err = s.Unmarshal(data, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ServerId != "game_1" || res.ClientPort != 8080 {
+		t.Fatalf("unexpected result:%+v", res)
+	}
+	if _, err = s.Marshal(map[string]any{}); err != ErrWrongValueType {
+		t.Fatalf("want wrong value type, got:%v", err)
+	}
+}
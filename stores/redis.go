@@ -162,6 +162,60 @@ func (s *StoreRedis) SetProtoNx(key string, value proto.Message, expire time.Dur
 	return s.Client.SetNX(ctx, s.GetKey(key), bs, expire).Err()
 }
 
+// SetRaw 原样写入字节,不做json编码
+func (s *StoreRedis) SetRaw(key string, value []byte, expire time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	return s.Client.Set(ctx, s.GetKey(key), value, expire).Err()
+}
+
+// SetRawNx 不存在时原样写入字节,返回是否写入
+func (s *StoreRedis) SetRawNx(key string, value []byte, expire time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	return s.Client.SetNX(ctx, s.GetKey(key), value, expire).Result()
+}
+
+func (s *StoreRedis) GetRaw(key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	return s.Client.Get(ctx, s.GetKey(key)).Bytes()
+}
+
+var (
+	casRawScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1`)
+	cadRawScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1])`)
+)
+
+// CompareAndSwapRaw 当前值等于old时设置为value,返回是否设置
+func (s *StoreRedis) CompareAndSwapRaw(key string, old, value []byte, expire time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	n, err := casRawScript.Run(ctx, s.Client, []string{s.GetKey(key)}, old, value, expire.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// CompareAndDelRaw 当前值等于old时删除,返回是否删除
+func (s *StoreRedis) CompareAndDelRaw(key string, old []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	n, err := cadRawScript.Run(ctx, s.Client, []string{s.GetKey(key)}, old).Int()
+	return n == 1, err
+}
+
 func (s *StoreRedis) Get(key string, val any) error {
 	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
 	defer cancel()
@@ -443,6 +497,34 @@ func (s *StoreRedis) BRPopString(key string) (string, error) {
 	return bss[1], nil
 }
 
+// LRangeRaw 原样读取列表元素
+func (s *StoreRedis) LRangeRaw(key string, start, stop int64) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	vals, err := s.Client.LRange(ctx, s.GetKey(key), start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	res := make([][]byte, len(vals))
+	for i, v := range vals {
+		res[i] = []byte(v)
+	}
+	return res, nil
+}
+
+// LRemRaw 按原始字节删除列表元素,返回删除数量
+func (s *StoreRedis) LRemRaw(key string, count int64, value []byte) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	return s.Client.LRem(ctx, s.GetKey(key), count, value).Result()
+}
+
+func (s *StoreRedis) LTrim(key string, start, stop int64) error {
+	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
+	defer cancel()
+	return s.Client.LTrim(ctx, s.GetKey(key), start, stop).Err()
+}
+
 func (s *StoreRedis) LLen(key string) int64 {
 	ctx, cancel := context.WithTimeout(context.TODO(), s.DialTimeout)
 	defer cancel()
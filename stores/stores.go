@@ -48,6 +48,11 @@ type StoreKeeper interface {
 	SetNx(key string, value any, expire time.Duration) error //set if not exist
 	SetProto(key string, value proto.Message, expire time.Duration) error
 	SetProtoNx(key string, value proto.Message, expire time.Duration) error
+	SetRaw(key string, value []byte, expire time.Duration) error
+	SetRawNx(key string, value []byte, expire time.Duration) (bool, error) //set if not exist, return whether set
+	GetRaw(key string) ([]byte, error)
+	CompareAndSwapRaw(key string, old, value []byte, expire time.Duration) (bool, error) //set if equal to old, return whether set
+	CompareAndDelRaw(key string, old []byte) (bool, error)                               //del if equal to old, return whether deleted
 	Get(key string, val any) error
 	GetInt(key string) int
 	GetString(key string) string
@@ -98,6 +103,9 @@ type StoreKeeper interface {
 	Incr(key string) (int64, error)
 	Decr(key string) (int64, error)
 	LLen(key string) int64
+	LRangeRaw(key string, start, stop int64) ([][]byte, error)
+	LRemRaw(key string, count int64, value []byte) (int64, error) //remove by raw value
+	LTrim(key string, start, stop int64) error
 	IsRedisNull(err error) bool
 	FlushDB() error
 	FlushDBAsync() error
@@ -125,6 +133,21 @@ func SetProto(key string, value proto.Message, expire time.Duration) error {
 func SetProtoNx(key string, value proto.Message, expire time.Duration) error {
 	return defStoreKeeper.SetProtoNx(key, value, expire)
 }
+func SetRaw(key string, value []byte, expire time.Duration) error {
+	return defStoreKeeper.SetRaw(key, value, expire)
+}
+func SetRawNx(key string, value []byte, expire time.Duration) (bool, error) {
+	return defStoreKeeper.SetRawNx(key, value, expire)
+}
+func GetRaw(key string) ([]byte, error) {
+	return defStoreKeeper.GetRaw(key)
+}
+func CompareAndSwapRaw(key string, old, value []byte, expire time.Duration) (bool, error) {
+	return defStoreKeeper.CompareAndSwapRaw(key, old, value, expire)
+}
+func CompareAndDelRaw(key string, old []byte) (bool, error) {
+	return defStoreKeeper.CompareAndDelRaw(key, old)
+}
 func Get(key string, val any) error {
 	return defStoreKeeper.Get(key, val)
 }
@@ -235,6 +258,18 @@ func LLen(key string) int64 {
 	return defStoreKeeper.LLen(key)
 }
 
+func LRangeRaw(key string, start, stop int64) ([][]byte, error) {
+	return defStoreKeeper.LRangeRaw(key, start, stop)
+}
+
+func LRemRaw(key string, count int64, value []byte) (int64, error) {
+	return defStoreKeeper.LRemRaw(key, count, value)
+}
+
+func LTrim(key string, start, stop int64) error {
+	return defStoreKeeper.LTrim(key, start, stop)
+}
+
 func IsRedisNull(err error) bool {
 	return defStoreKeeper.IsRedisNull(err)
 }